@@ -15,13 +15,19 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp" // TODO: Add all auth providers
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
 
 	"github.com/lwolf/kube-cleanup-operator/pkg/controller"
+	"github.com/lwolf/kube-cleanup-operator/pkg/leader"
+	"github.com/lwolf/kube-cleanup-operator/pkg/shard"
+	"github.com/lwolf/kube-cleanup-operator/pkg/webhook"
 )
 
 var (
@@ -43,14 +49,29 @@ func setupLogging() {
 func main() {
 	runOutsideCluster := flag.Bool("run-outside-cluster", false, "Set this flag when running outside of the cluster.")
 	namespace := flag.String("namespace", "", "Limit scope to a single namespace")
+	namespaces := flag.String("namespaces", "", "Comma separated list of namespaces to limit the scope to")
+	excludeNamespaces := flag.String("exclude-namespaces", "", "Comma separated list of namespaces never to touch, glob patterns are supported, e.g. kube-*")
+	namespaceSelector := flag.String("namespace-selector", "", "Limit scope to namespaces with labels matching the selector")
 	listenAddr := flag.String("listen-addr", "0.0.0.0:7000", "Address to expose metrics.")
 
 	deleteSuccessAfter := flag.Duration("delete-successful-after", 15*time.Minute, "Delete jobs and pods in successful state after X duration (golang duration format, e.g 5m), 0 - never delete")
 	deleteFailedAfter := flag.Duration("delete-failed-after", 0, "Delete jobs and pods in failed state after X duration (golang duration format, e.g 5m), 0 - never delete")
 	deleteOrphanedAfter := flag.Duration("delete-orphaned-pods-after", 1*time.Hour, "Delete orphaned pods. Pods without an owner in non-running state (golang duration format, e.g 5m), 0 - never delete")
 	deleteEvictedAfter := flag.Duration("delete-evicted-pods-after", 15*time.Minute, "Delete pods in evicted state (golang duration format, e.g 5m), 0 - never delete")
-	deletePendingAfter := flag.Duration("delete-pending-pods-after", 0, "Delete pods in pending state after X duration (golang duration format, e.g 5m), 0 - never delete")
+	deletePendingAfter := flag.Duration("delete-pending-pods-after", 0, "Delete unschedulable pods in pending state after X duration (golang duration format, e.g 5m), 0 - never delete")
+	deleteCreatingAfter := flag.Duration("delete-creating-pods-after", 0, "Delete scheduled pods in pending state creating their containers after X duration (golang duration format, e.g 5m), 0 - never delete")
+	deleteInitializingAfter := flag.Duration("delete-initializing-pods-after", 0, "Delete scheduled pods in pending state running their init containers after X duration (golang duration format, e.g 5m), 0 - never delete")
+	deleteWaitingAfter := flag.String("delete-waiting-pods-after", "", "Comma separated list of container waiting reasons and durations to delete pods after, e.g. CrashLoopBackOff=1h,ImagePullBackOff=30m")
+	deleteTerminatedAfter := flag.String("delete-terminated-pods-after", "", "Comma separated list of reasons of Failed pods terminated by the node and durations to delete them after, e.g. NodeShutdown=1h,Terminated=1h,Preempting=30m")
+	waitingPodsAnyOwner := flag.Bool("waiting-pods-any-owner", false, "Apply delete-waiting-pods-after to pods of any owner, not only to pods owned by jobs and orphaned pods")
+	deleteTerminatingAfter := flag.Duration("delete-terminating-pods-after", 0, "Force delete pods stuck in Terminating for X duration after their grace period (golang duration format, e.g 1h, at least 1m), 0 - never delete")
+	deleteLostNodePodsAfter := flag.Duration("delete-lost-node-pods-after", 0, "Force delete pods bound to nodes NotReady or deleted, and pods in Unknown phase, after X duration (golang duration format, e.g 15m, at least 1m), 0 - never delete")
+	removableFinalizers := flag.String("removable-finalizers", "", "Comma separated list of finalizers removed from pods stuck in Terminating before they are force deleted, pods with other finalizers are not force deleted")
 	ignoreOwnedByCronjob := flag.Bool("ignore-owned-by-cronjobs", false, "[EXPERIMENTAL] Do not cleanup pods and jobs created by cronjobs")
+	keepLastSuccessful := flag.Int("keep-last-successful-jobs", 0, "Keep N most recent successful jobs per owner and delete the rest regardless of age, 0 - use delete-successful-after")
+	keepLastFailed := flag.Int("keep-last-failed-jobs", 0, "Keep N most recent failed jobs per owner and delete the rest regardless of age, 0 - use delete-failed-after")
+	jobHistoryGroupLabel := flag.String("job-history-group-label", "", "Group jobs by the value of this label instead of their owner when counting keep-last-* jobs")
+	ttlAfterFinishedMode := flag.String("ttl-after-finished-mode", controller.TTLModeIgnore, "Handling of jobs with ttlSecondsAfterFinished: `ignore` - use delete-* flags, `defer` - leave them to the TTL controller, `use` - delete them when ttl expires")
 
 	legacyKeepSuccessHours := flag.Int64("keep-successful", 0, "Number of hours to keep successful jobs, -1 - forever, 0 - never (default), >0 number of hours")
 	legacyKeepFailedHours := flag.Int64("keep-failures", -1, "Number of hours to keep failed jobs, -1 - forever (default) 0 - never, >0 number of hours")
@@ -58,8 +79,34 @@ func main() {
 	legacyMode := flag.Bool("legacy-mode", true, "Legacy mode: `true` - use old `keep-*` flags, `false` - enable new `delete-*-after` flags")
 
 	dryRun := flag.Bool("dry-run", false, "Print only, do not delete anything.")
+	leaderElect := flag.Bool("leader-elect", false, "Run only on the replica holding the Lease, required when running more than one replica")
+	leaderElectLeaseName := flag.String("leader-elect-lease-name", "kube-cleanup-operator", "Name of the Lease used for leader election")
+	leaderElectNamespace := flag.String("leader-elect-namespace", "", "Namespace of the Lease used for leader election, empty - namespace of the operator")
+	leaderElectLeaseDuration := flag.Duration("leader-elect-lease-duration", 15*time.Second, "Duration followers wait before taking over the Lease of a leader that stopped renewing it")
+	leaderElectRenewDeadline := flag.Duration("leader-elect-renew-deadline", 10*time.Second, "Duration the leader retries renewing the Lease before giving up the leadership")
+	leaderElectRetryPeriod := flag.Duration("leader-elect-retry-period", 2*time.Second, "Duration between attempts to acquire or renew the Lease")
+	shardGroup := flag.String("shard-group", "", "Split namespaces across the replicas sharing this group name, tracked with a Lease per replica, empty - disabled")
+	shardNamespace := flag.String("shard-namespace", "", "Namespace of the shard Leases, empty - namespace of the operator")
+	shardLabel := flag.String("shard-label", "", "Assign namespaces by the value of this namespace label instead of their name")
+	shardLeaseDuration := flag.Duration("shard-lease-duration", 30*time.Second, "Duration after which a replica that stopped renewing its shard Lease is considered gone")
+	workers := flag.Int("workers", 1, "Number of jobs and pods processed concurrently, namespaces are processed in turns")
 	
 	labelSelector := flag.String("label-selector", "", "Delete only jobs and pods that meet label selector requirements")
+	jobLabelSelector := flag.String("job-label-selector", "", "Delete only jobs that meet label selector requirements, pods of other jobs are skipped")
+	podLabelSelector := flag.String("pod-label-selector", "", "Delete only pods that meet label selector requirements")
+	excludeJobSelector := flag.String("exclude-job-selector", "", "Never delete jobs, and their pods, with labels matching the selector")
+	excludePodSelector := flag.String("exclude-pod-selector", "", "Never delete pods with labels matching the selector")
+	excludeOwnedBy := flag.String("exclude-owned-by", "", "Comma separated list of owner kinds whose jobs and pods are never deleted, e.g. CronJob")
+	skipRunningPods := flag.Bool("skip-running-pods", false, "Do not watch Running pods, they are filtered out by the apiserver with a status.phase field selector")
+
+	enableCleanupPolicies := flag.Bool("enable-cleanup-policies", false, "Watch CleanupPolicy and ClusterCleanupPolicy objects to override delete-* flags, requires CRDs to be installed")
+	enableNamespaceOverrides := flag.Bool("enable-namespace-overrides", false, "Allow annotations on Namespace objects to override delete-* flags for all jobs and pods inside")
+
+	configFile := flag.String("config", "", "Path to YAML/JSON config file, its values take precedence over flags. File is reloaded on change")
+
+	webhookListenAddr := flag.String("webhook-listen-addr", "", "Address to serve the mutating webhook injecting ttlSecondsAfterFinished into jobs and cronjobs, empty - disabled")
+	webhookCertFile := flag.String("webhook-tls-cert-file", "/etc/webhook/certs/tls.crt", "Path to the TLS certificate of the webhook")
+	webhookKeyFile := flag.String("webhook-tls-key-file", "/etc/webhook/certs/tls.key", "Path to the TLS key of the webhook")
 	
 	flag.Parse()
 	setupLogging()
@@ -68,13 +115,29 @@ func main() {
 	var optsInfo strings.Builder
 	optsInfo.WriteString("Provided options: \n")
 	optsInfo.WriteString(fmt.Sprintf("\tnamespace: %s\n", *namespace))
+	optsInfo.WriteString(fmt.Sprintf("\tnamespaces: %s\n", *namespaces))
+	optsInfo.WriteString(fmt.Sprintf("\texclude-namespaces: %s\n", *excludeNamespaces))
+	optsInfo.WriteString(fmt.Sprintf("\tnamespace-selector: %s\n", *namespaceSelector))
 	optsInfo.WriteString(fmt.Sprintf("\tdry-run: %v\n", *dryRun))
+	optsInfo.WriteString(fmt.Sprintf("\tworkers: %d\n", *workers))
 	optsInfo.WriteString(fmt.Sprintf("\tdelete-successful-after: %s\n", *deleteSuccessAfter))
 	optsInfo.WriteString(fmt.Sprintf("\tdelete-failed-after: %s\n", *deleteFailedAfter))
 	optsInfo.WriteString(fmt.Sprintf("\tdelete-pending-after: %s\n", *deletePendingAfter))
+	optsInfo.WriteString(fmt.Sprintf("\tdelete-creating-pods-after: %s\n", *deleteCreatingAfter))
+	optsInfo.WriteString(fmt.Sprintf("\tdelete-initializing-pods-after: %s\n", *deleteInitializingAfter))
 	optsInfo.WriteString(fmt.Sprintf("\tdelete-orphaned-after: %s\n", *deleteOrphanedAfter))
 	optsInfo.WriteString(fmt.Sprintf("\tdelete-evicted-after: %s\n", *deleteEvictedAfter))
+	optsInfo.WriteString(fmt.Sprintf("\tdelete-waiting-pods-after: %s\n", *deleteWaitingAfter))
+	optsInfo.WriteString(fmt.Sprintf("\twaiting-pods-any-owner: %v\n", *waitingPodsAnyOwner))
+	optsInfo.WriteString(fmt.Sprintf("\tdelete-terminated-pods-after: %s\n", *deleteTerminatedAfter))
+	optsInfo.WriteString(fmt.Sprintf("\tdelete-terminating-pods-after: %s\n", *deleteTerminatingAfter))
+	optsInfo.WriteString(fmt.Sprintf("\tdelete-lost-node-pods-after: %s\n", *deleteLostNodePodsAfter))
+	optsInfo.WriteString(fmt.Sprintf("\tremovable-finalizers: %s\n", *removableFinalizers))
 	optsInfo.WriteString(fmt.Sprintf("\tignore-owned-by-cronjobs: %v\n", *ignoreOwnedByCronjob))
+	optsInfo.WriteString(fmt.Sprintf("\tkeep-last-successful-jobs: %d\n", *keepLastSuccessful))
+	optsInfo.WriteString(fmt.Sprintf("\tkeep-last-failed-jobs: %d\n", *keepLastFailed))
+	optsInfo.WriteString(fmt.Sprintf("\tjob-history-group-label: %s\n", *jobHistoryGroupLabel))
+	optsInfo.WriteString(fmt.Sprintf("\tttl-after-finished-mode: %s\n", *ttlAfterFinishedMode))
 
 	optsInfo.WriteString(fmt.Sprintf("\n\tlegacy-mode: %v\n", *legacyMode))
 	optsInfo.WriteString(fmt.Sprintf("\tkeep-successful: %d\n", *legacyKeepSuccessHours))
@@ -82,8 +145,111 @@ func main() {
 	optsInfo.WriteString(fmt.Sprintf("\tkeep-pending: %d\n", *legacyKeepPendingHours))
 	
 	optsInfo.WriteString(fmt.Sprintf("\tlabel-selector: %s\n", *labelSelector))
+	optsInfo.WriteString(fmt.Sprintf("\tjob-label-selector: %s\n", *jobLabelSelector))
+	optsInfo.WriteString(fmt.Sprintf("\tpod-label-selector: %s\n", *podLabelSelector))
+	optsInfo.WriteString(fmt.Sprintf("\texclude-job-selector: %s\n", *excludeJobSelector))
+	optsInfo.WriteString(fmt.Sprintf("\texclude-pod-selector: %s\n", *excludePodSelector))
+	optsInfo.WriteString(fmt.Sprintf("\texclude-owned-by: %s\n", *excludeOwnedBy))
+	optsInfo.WriteString(fmt.Sprintf("\tskip-running-pods: %v\n", *skipRunningPods))
+	optsInfo.WriteString(fmt.Sprintf("\tenable-cleanup-policies: %v\n", *enableCleanupPolicies))
+	optsInfo.WriteString(fmt.Sprintf("\tenable-namespace-overrides: %v\n", *enableNamespaceOverrides))
+	optsInfo.WriteString(fmt.Sprintf("\tconfig: %s\n", *configFile))
+	optsInfo.WriteString(fmt.Sprintf("\twebhook-listen-addr: %s\n", *webhookListenAddr))
+	optsInfo.WriteString(fmt.Sprintf("\tleader-elect: %v\n", *leaderElect))
+	optsInfo.WriteString(fmt.Sprintf("\tshard-group: %s\n", *shardGroup))
+	optsInfo.WriteString(fmt.Sprintf("\tshard-label: %s\n", *shardLabel))
 	log.Println(optsInfo.String())
 
+	waitingDurations, err := parseDurations(*deleteWaitingAfter)
+	if err != nil {
+		log.Fatalf("invalid delete-waiting-pods-after: %v", err)
+	}
+	terminatedDurations, err := parseDurations(*deleteTerminatedAfter)
+	if err != nil {
+		log.Fatalf("invalid delete-terminated-pods-after: %v", err)
+	}
+	flagsConfig := controller.Config{
+		Namespace:                   *namespace,
+		Namespaces:                  splitList(*namespaces),
+		ExcludeNamespaces:           splitList(*excludeNamespaces),
+		NamespaceSelector:           *namespaceSelector,
+		LabelSelector:               *labelSelector,
+		JobLabelSelector:            *jobLabelSelector,
+		PodLabelSelector:            *podLabelSelector,
+		ExcludeJobSelector:          *excludeJobSelector,
+		ExcludePodSelector:          *excludePodSelector,
+		ExcludeOwnedBy:              splitList(*excludeOwnedBy),
+		SkipRunningPods:             *skipRunningPods,
+		DryRun:                      *dryRun,
+		Workers:                     *workers,
+		DeleteSuccessfulAfter:       metav1.Duration{Duration: *deleteSuccessAfter},
+		DeleteFailedAfter:           metav1.Duration{Duration: *deleteFailedAfter},
+		DeletePendingPodsAfter:      metav1.Duration{Duration: *deletePendingAfter},
+		DeleteOrphanedPodsAfter:     metav1.Duration{Duration: *deleteOrphanedAfter},
+		DeleteEvictedPodsAfter:      metav1.Duration{Duration: *deleteEvictedAfter},
+		DeleteCreatingPodsAfter:     metav1.Duration{Duration: *deleteCreatingAfter},
+		DeleteInitializingPodsAfter: metav1.Duration{Duration: *deleteInitializingAfter},
+		DeleteWaitingPodsAfter:      waitingDurations,
+		WaitingPodsAnyOwner:         *waitingPodsAnyOwner,
+		DeleteTerminatedPodsAfter:   terminatedDurations,
+		DeleteTerminatingPodsAfter:  metav1.Duration{Duration: *deleteTerminatingAfter},
+		DeleteLostNodePodsAfter:     metav1.Duration{Duration: *deleteLostNodePodsAfter},
+		RemovableFinalizers:         splitList(*removableFinalizers),
+		IgnoreOwnedByCronjobs:       *ignoreOwnedByCronjob,
+		KeepLastSuccessfulJobs:      *keepLastSuccessful,
+		KeepLastFailedJobs:          *keepLastFailed,
+		JobHistoryGroupLabel:        *jobHistoryGroupLabel,
+		TTLAfterFinishedMode:        *ttlAfterFinishedMode,
+		EnableCleanupPolicies:       *enableCleanupPolicies,
+		EnableNamespaceOverrides:    *enableNamespaceOverrides,
+	}
+	kleanerConfig := flagsConfig
+	if *configFile != "" {
+		if *legacyMode {
+			log.Fatalf("config file is not supported in legacy mode, set -legacy-mode=false")
+		}
+		var err error
+		kleanerConfig, err = controller.LoadConfig(*configFile, flagsConfig)
+		if err != nil {
+			log.Fatalf("failed to load config '%s': %v", *configFile, err)
+		}
+	} else if err := kleanerConfig.Validate(); err != nil {
+		log.Fatalf("invalid options: %v", err)
+	}
+	if *webhookListenAddr != "" && *legacyMode {
+		log.Fatalf("webhook is not supported in legacy mode, set -legacy-mode=false")
+	}
+	if (*namespaces != "" || *excludeNamespaces != "" || *namespaceSelector != "") && *legacyMode {
+		log.Fatalf("namespaces, exclude-namespaces and namespace-selector are not supported in legacy mode, set -legacy-mode=false")
+	}
+	if (*jobLabelSelector != "" || *podLabelSelector != "" || *excludeJobSelector != "" || *excludePodSelector != "" || *excludeOwnedBy != "") && *legacyMode {
+		log.Fatalf("job/pod label selectors and exclusions are not supported in legacy mode, set -legacy-mode=false")
+	}
+	if (*deleteWaitingAfter != "" || *deleteTerminatedAfter != "" || *deleteCreatingAfter != 0 || *deleteInitializingAfter != 0) && *legacyMode {
+		log.Fatalf("delete-waiting-pods-after, delete-terminated-pods-after, delete-creating-pods-after and delete-initializing-pods-after are not supported in legacy mode, set -legacy-mode=false")
+	}
+	if (*deleteTerminatingAfter != 0 || *deleteLostNodePodsAfter != 0 || *removableFinalizers != "") && *legacyMode {
+		log.Fatalf("delete-terminating-pods-after and delete-lost-node-pods-after are not supported in legacy mode, set -legacy-mode=false")
+	}
+	if *shardGroup != "" && *legacyMode {
+		log.Fatalf("sharding is not supported in legacy mode, set -legacy-mode=false")
+	}
+	if (*enableCleanupPolicies || *enableNamespaceOverrides) && *legacyMode {
+		log.Fatalf("enable-cleanup-policies and enable-namespace-overrides are not supported in legacy mode, set -legacy-mode=false")
+	}
+	if (*keepLastSuccessful != 0 || *keepLastFailed != 0 || *jobHistoryGroupLabel != "") && *legacyMode {
+		log.Fatalf("keep-last-successful-jobs, keep-last-failed-jobs and job-history-group-label are not supported in legacy mode, set -legacy-mode=false")
+	}
+	if *ttlAfterFinishedMode != controller.TTLModeIgnore && *legacyMode {
+		log.Fatalf("ttl-after-finished-mode is not supported in legacy mode, set -legacy-mode=false")
+	}
+	if (*workers != 1 || *skipRunningPods) && *legacyMode {
+		log.Fatalf("workers and skip-running-pods are not supported in legacy mode, set -legacy-mode=false")
+	}
+	if *shardGroup != "" && *leaderElect {
+		log.Fatalf("sharding and leader election can not be enabled together, every shard member does its part of the work")
+	}
+
 	if *legacyMode {
 		var warning strings.Builder
 		warning.WriteString("\n!!! DEPRECATION WARNING !!!\n")
@@ -103,14 +269,57 @@ func main() {
 	wg := &sync.WaitGroup{}
 
 	// Create clientset for interacting with the kubernetes cluster
-	clientset, err := newClientSet(*runOutsideCluster)
+	restConfig, err := newRestConfig(*runOutsideCluster)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// dynamic client is only needed to watch CleanupPolicy objects and custom resources
+	var dynamicClient dynamic.Interface
+	if kleanerConfig.EnableCleanupPolicies || len(kleanerConfig.Resources) > 0 {
+		dynamicClient, err = dynamic.NewForConfig(restConfig)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
 	ctx := context.Background()
 
-	wg.Add(1)
-	go func() {
+	var webhookServer *webhook.Server
+	if *webhookListenAddr != "" {
+		webhookServer = webhook.NewServer(*webhookListenAddr, *webhookCertFile, *webhookKeyFile, kleanerConfig)
+		wg.Add(1)
+		go func() {
+			if err := webhookServer.Run(stopCh); err != nil {
+				log.Fatalf("failed to serve webhook: %v\n", err)
+			}
+			wg.Done()
+		}()
+		log.Printf("Webhook listening at %s", *webhookListenAddr)
+	}
+
+	// followers serve the webhook as well, so the config file is watched regardless of the leadership
+	var (
+		configMu      sync.Mutex
+		currentConfig = kleanerConfig
+		kleaner       *controller.Kleaner
+	)
+	if *configFile != "" {
+		go controller.WatchConfig(*configFile, flagsConfig, stopCh, func(cfg controller.Config) {
+			configMu.Lock()
+			currentConfig = cfg
+			if kleaner != nil {
+				kleaner.UpdateConfig(cfg)
+			}
+			configMu.Unlock()
+			if webhookServer != nil {
+				webhookServer.UpdateConfig(cfg)
+			}
+		})
+	}
+	runController := func(stopCh <-chan struct{}) {
 		if *legacyMode {
 			controller.NewPodController(
 				ctx,
@@ -122,21 +331,45 @@ func main() {
 				*legacyKeepPendingHours,
 				stopCh,
 			).Run()
+			return
+		}
+		var members *shard.Members
+		if *shardGroup != "" {
+			if *shardNamespace == "" {
+				*shardNamespace = leader.DefaultNamespace()
+			}
+			var err error
+			members, err = shard.NewMembers(ctx, clientset, shard.Config{
+				Group:         *shardGroup,
+				Namespace:     *shardNamespace,
+				LeaseDuration: *shardLeaseDuration,
+				Label:         *shardLabel,
+			})
+			if err != nil {
+				log.Fatalf("invalid shard options: %v", err)
+			}
+		}
+		configMu.Lock()
+		kleaner = controller.NewKleaner(ctx, clientset, dynamicClient, currentConfig, members, stopCh)
+		configMu.Unlock()
+		kleaner.Run()
+	}
+
+	wg.Add(1)
+	go func() {
+		if *leaderElect {
+			err := leader.Run(ctx, clientset, leader.Config{
+				LeaseName:      *leaderElectLeaseName,
+				LeaseNamespace: *leaderElectNamespace,
+				LeaseDuration:  *leaderElectLeaseDuration,
+				RenewDeadline:  *leaderElectRenewDeadline,
+				RetryPeriod:    *leaderElectRetryPeriod,
+			}, stopCh, runController)
+			if err != nil {
+				log.Fatalf("failed to run leader election: %v\n", err)
+			}
 		} else {
-			controller.NewKleaner(
-				ctx,
-				clientset,
-				*namespace,
-				*dryRun,
-				*deleteSuccessAfter,
-				*deleteFailedAfter,
-				*deletePendingAfter,
-				*deleteOrphanedAfter,
-				*deleteEvictedAfter,
-				*ignoreOwnedByCronjob,
-				*labelSelector,
-				stopCh,
-			).Run()
+			runController(stopCh)
 		}
 		wg.Done()
 	}()
@@ -177,7 +410,7 @@ func main() {
 	wg.Wait()     // Wait for all to be stopped
 }
 
-func newClientSet(runOutsideCluster bool) (*kubernetes.Clientset, error) {
+func newRestConfig(runOutsideCluster bool) (*rest.Config, error) {
 	kubeConfigLocation := ""
 
 	if runOutsideCluster {
@@ -190,11 +423,37 @@ func newClientSet(runOutsideCluster bool) (*kubernetes.Clientset, error) {
 	}
 
 	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfigLocation)
+	return clientcmd.BuildConfigFromFlags("", kubeConfigLocation)
+}
 
-	if err != nil {
-		return nil, err
+// splitList splits a comma separated flag value, empty items are dropped
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
 	}
+	return result
+}
 
-	return kubernetes.NewForConfig(config)
+// parseDurations parses a comma separated list of key=duration pairs
+func parseDurations(value string) (map[string]metav1.Duration, error) {
+	items := splitList(value)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]metav1.Duration, len(items))
+	for _, item := range items {
+		key, duration, found := strings.Cut(item, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("expected key=duration, got '%s'", item)
+		}
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration of '%s': %v", key, err)
+		}
+		result[key] = metav1.Duration{Duration: d}
+	}
+	return result, nil
 }
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"log"
+	"time"
+)
+
+// Annotations that change the retention of a single Job or Pod.
+// CronJobs can set them in the jobTemplate metadata, they are copied to every Job created.
+const (
+	// AnnotationPrefix is shared by all annotations of the operator
+	AnnotationPrefix = "cleanup.kube-cleanup-operator/"
+
+	deleteSuccessfulAfterAnnotation   = AnnotationPrefix + "delete-successful-after"
+	deleteFailedAfterAnnotation       = AnnotationPrefix + "delete-failed-after"
+	deletePendingAfterAnnotation      = AnnotationPrefix + "delete-pending-pods-after"
+	deleteOrphanedAfterAnnotation     = AnnotationPrefix + "delete-orphaned-pods-after"
+	deleteEvictedAfterAnnotation      = AnnotationPrefix + "delete-evicted-pods-after"
+	deleteCreatingAfterAnnotation     = AnnotationPrefix + "delete-creating-pods-after"
+	deleteInitializingAfterAnnotation = AnnotationPrefix + "delete-initializing-pods-after"
+
+	// keepAnnotation set to "true" protects the object from being deleted
+	keepAnnotation = AnnotationPrefix + "keep"
+	// keepUntilAnnotation protects the object from being deleted until the given RFC3339 timestamp
+	keepUntilAnnotation = AnnotationPrefix + "keep-until"
+)
+
+// annotatedDuration returns the duration set in the annotation or fallback if the annotation is missing or invalid
+func annotatedDuration(annotations map[string]string, key string, fallback time.Duration) time.Duration {
+	value, ok := annotations[key]
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Printf("invalid value '%s' of annotation '%s', using %s instead", value, key, fallback)
+		return fallback
+	}
+	return d
+}
+
+// withAnnotations overrides the thresholds of r with the ones set in the annotations
+func (r retention) withAnnotations(annotations map[string]string) retention {
+	r.deleteSuccessfulAfter = annotatedDuration(annotations, deleteSuccessfulAfterAnnotation, r.deleteSuccessfulAfter)
+	r.deleteFailedAfter = annotatedDuration(annotations, deleteFailedAfterAnnotation, r.deleteFailedAfter)
+	r.deletePendingAfter = annotatedDuration(annotations, deletePendingAfterAnnotation, r.deletePendingAfter)
+	r.deleteOrphanedAfter = annotatedDuration(annotations, deleteOrphanedAfterAnnotation, r.deleteOrphanedAfter)
+	r.deleteEvictedAfter = annotatedDuration(annotations, deleteEvictedAfterAnnotation, r.deleteEvictedAfter)
+	r.deleteCreatingAfter = annotatedDuration(annotations, deleteCreatingAfterAnnotation, r.deleteCreatingAfter)
+	r.deleteInitializingAfter = annotatedDuration(annotations, deleteInitializingAfterAnnotation, r.deleteInitializingAfter)
+	return r
+}
+
+// isProtected returns true if the object must not be deleted because of keep or keep-until annotations
+func isProtected(annotations map[string]string, now time.Time) bool {
+	if annotations[keepAnnotation] == "true" {
+		return true
+	}
+	value, ok := annotations[keepUntilAnnotation]
+	if !ok {
+		return false
+	}
+	keepUntil, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		// keep the object rather than deleting something the user asked to preserve
+		log.Printf("invalid value '%s' of annotation '%s', object is kept", value, keepUntilAnnotation)
+		return true
+	}
+	return now.Before(keepUntil)
+}
+
+// keepUntil returns the time the keep-until annotation protects the object until,
+// zero if the annotation is missing, invalid or the object is kept forever
+func keepUntil(annotations map[string]string) time.Time {
+	if annotations[keepAnnotation] == "true" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, annotations[keepUntilAnnotation])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsProtected(t *testing.T) {
+	ts := time.Now()
+	testCases := map[string]struct {
+		annotations map[string]string
+		expected    bool
+	}{
+		"objects without annotations are not protected": {
+			annotations: nil,
+			expected:    false,
+		},
+		"keep annotation protects the object": {
+			annotations: map[string]string{keepAnnotation: "true"},
+			expected:    true,
+		},
+		"keep annotation with other values is ignored": {
+			annotations: map[string]string{keepAnnotation: "no"},
+			expected:    false,
+		},
+		"keep-until in the future protects the object": {
+			annotations: map[string]string{keepUntilAnnotation: ts.Add(time.Hour).Format(time.RFC3339)},
+			expected:    true,
+		},
+		"expired keep-until does not protect the object": {
+			annotations: map[string]string{keepUntilAnnotation: ts.Add(-time.Hour).Format(time.RFC3339)},
+			expected:    false,
+		},
+		"invalid keep-until protects the object": {
+			annotations: map[string]string{keepUntilAnnotation: "tomorrow"},
+			expected:    true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := isProtected(tc.annotations, ts)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
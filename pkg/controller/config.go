@@ -0,0 +1,410 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const configPollPeriod = 10 * time.Second
+
+// Modes of handling jobs with spec.ttlSecondsAfterFinished set
+const (
+	// TTLModeIgnore handles such jobs like any other job
+	TTLModeIgnore = "ignore"
+	// TTLModeDefer leaves such jobs and their pods to the TTL-after-finished controller
+	TTLModeDefer = "defer"
+	// TTLModeUse deletes such jobs when their ttl expires instead of using the delete-* durations
+	TTLModeUse = "use"
+)
+
+// Config holds the settings of the Kleaner. It is built from the command line flags
+// and can be overridden by a YAML or JSON file.
+type Config struct {
+	// Namespace limits the scope to a single namespace, empty - all namespaces
+	Namespace string `json:"namespace"`
+	// Namespaces limits the scope to the listed namespaces, NamespaceSelector to namespaces with matching labels,
+	// ExcludeNamespaces is a list of glob patterns of namespaces removed from the scope, e.g. `kube-*`
+	Namespaces        []string `json:"namespaces,omitempty"`
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	NamespaceSelector string   `json:"namespaceSelector"`
+	// LabelSelector limits the scope to jobs and pods with matching labels, JobLabelSelector and PodLabelSelector
+	// only to jobs or pods respectively. All of them are evaluated by the apiserver.
+	LabelSelector    string `json:"labelSelector"`
+	JobLabelSelector string `json:"jobLabelSelector"`
+	PodLabelSelector string `json:"podLabelSelector"`
+	// ExcludeJobSelector and ExcludePodSelector exclude jobs and pods with matching labels, ExcludeOwnedBy excludes
+	// jobs and pods owned by the listed kinds, e.g. CronJob. Pods of excluded jobs are excluded as well.
+	ExcludeJobSelector string   `json:"excludeJobSelector"`
+	ExcludePodSelector string   `json:"excludePodSelector"`
+	ExcludeOwnedBy     []string `json:"excludeOwnedBy,omitempty"`
+	// SkipRunningPods filters Running pods out on the apiserver, they are neither watched nor cached
+	SkipRunningPods bool `json:"skipRunningPods"`
+	// DryRun prints objects to be deleted instead of deleting them
+	DryRun bool `json:"dryRun"`
+	// Workers is the number of objects processed concurrently, 0 - 1 worker
+	Workers int `json:"workers"`
+
+	DeleteSuccessfulAfter   metav1.Duration `json:"deleteSuccessfulAfter"`
+	DeleteFailedAfter       metav1.Duration `json:"deleteFailedAfter"`
+	DeletePendingPodsAfter  metav1.Duration `json:"deletePendingPodsAfter"`
+	DeleteOrphanedPodsAfter metav1.Duration `json:"deleteOrphanedPodsAfter"`
+	DeleteEvictedPodsAfter  metav1.Duration `json:"deleteEvictedPodsAfter"`
+	// DeleteCreatingPodsAfter and DeleteInitializingPodsAfter delete Pending pods stuck creating their containers
+	// or running their init containers, DeletePendingPodsAfter applies only to pods which are not scheduled
+	DeleteCreatingPodsAfter     metav1.Duration `json:"deleteCreatingPodsAfter"`
+	DeleteInitializingPodsAfter metav1.Duration `json:"deleteInitializingPodsAfter"`
+	// DeleteWaitingPodsAfter deletes pods with a container waiting for one of the reasons, e.g. CrashLoopBackOff,
+	// for longer than its duration. Only pods owned by Jobs and orphaned pods are deleted unless WaitingPodsAnyOwner is set.
+	DeleteWaitingPodsAfter map[string]metav1.Duration `json:"deleteWaitingPodsAfter,omitempty"`
+	WaitingPodsAnyOwner    bool                       `json:"waitingPodsAnyOwner"`
+	// DeleteTerminatedPodsAfter deletes Failed pods of any owner terminated by the node for one of the reasons,
+	// e.g. NodeShutdown or Preempting, after its duration since the termination. Evicted pods use DeleteEvictedPodsAfter.
+	DeleteTerminatedPodsAfter map[string]metav1.Duration `json:"deleteTerminatedPodsAfter,omitempty"`
+	IgnoreOwnedByCronjobs     bool                       `json:"ignoreOwnedByCronjobs"`
+	// DeleteTerminatingPodsAfter force deletes pods stuck in Terminating for longer than this after their grace period,
+	// 0 - never. Pods with finalizers are force deleted only if all of them are listed in RemovableFinalizers.
+	DeleteTerminatingPodsAfter metav1.Duration `json:"deleteTerminatingPodsAfter"`
+	// DeleteLostNodePodsAfter force deletes pods bound to nodes NotReady or deleted for longer than this,
+	// as well as pods in the Unknown phase, 0 - never. Finalizers are handled as for terminating pods.
+	DeleteLostNodePodsAfter metav1.Duration `json:"deleteLostNodePodsAfter"`
+	RemovableFinalizers     []string        `json:"removableFinalizers,omitempty"`
+
+	// KeepLastSuccessfulJobs and KeepLastFailedJobs keep the given number of the most recent finished jobs
+	// per owner and delete the older ones regardless of age, 0 - use the delete-* durations
+	KeepLastSuccessfulJobs int `json:"keepLastSuccessfulJobs"`
+	KeepLastFailedJobs     int `json:"keepLastFailedJobs"`
+	// JobHistoryGroupLabel groups jobs by the value of this label instead of their owner
+	JobHistoryGroupLabel string `json:"jobHistoryGroupLabel"`
+	// TTLAfterFinishedMode is one of `ignore`, `defer` or `use`, empty - ignore
+	TTLAfterFinishedMode string `json:"ttlAfterFinishedMode"`
+
+	// EnableCleanupPolicies enables watching CleanupPolicy and ClusterCleanupPolicy objects
+	EnableCleanupPolicies bool `json:"enableCleanupPolicies"`
+	// EnableNamespaceOverrides enables watching Namespace objects for retention annotations
+	EnableNamespaceOverrides bool `json:"enableNamespaceOverrides"`
+
+	// Policies are evaluated in order, every matching policy overrides the fields it sets
+	Policies []ConfigPolicy `json:"policies,omitempty"`
+
+	// Rules are evaluated in order before the delete-* settings, the first matching rule wins
+	Rules []Rule `json:"rules,omitempty"`
+	// ArchiveDir is the directory objects are written to by the archive action
+	ArchiveDir string `json:"archiveDir,omitempty"`
+
+	// Resources are custom resources deleted after their completion besides Jobs and Pods,
+	// adding or removing resources and changing their label selectors require restart
+	Resources []ResourceConfig `json:"resources,omitempty"`
+}
+
+// ConfigPolicy is a block of retention settings in the configuration file,
+// it applies to Jobs and Pods from the matching namespaces with labels matching the selector
+type ConfigPolicy struct {
+	Name string `json:"name,omitempty"`
+	// Namespaces is a list of glob patterns, e.g. `ci-*`, empty - all namespaces
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	CleanupPolicySpec `json:",inline"`
+}
+
+// kleanerSettings are the parts of Config that can be changed without restarting the informers
+type kleanerSettings struct {
+	dryRun     bool
+	ttlMode    string
+	defaults   retention
+	policies   []configPolicy
+	rules      []rule
+	archiveDir string
+	exclusions exclusions
+	// terminating pods and pods of lost nodes are force deleted regardless of policies and annotations
+	deleteTerminatingAfter  time.Duration
+	deleteLostNodePodsAfter time.Duration
+	removableFinalizers     []string
+	waitingPodsAnyOwner     bool
+	// resources holds the custom resources by resourceKey
+	resources map[string]*resourceCleanup
+}
+
+// configPolicy is the parsed version of ConfigPolicy
+type configPolicy struct {
+	ConfigPolicy
+	selector  labels.Selector
+	condition *expression
+}
+
+// apply overrides the thresholds and the condition of r with the ones set in the policy
+func (p *configPolicy) apply(r retention) retention {
+	r = p.CleanupPolicySpec.apply(r)
+	if p.condition != nil {
+		r.condition = p.condition
+	}
+	return r
+}
+
+func (p *configPolicy) matches(meta *metav1.ObjectMeta) bool {
+	if !p.selector.Matches(labels.Set(meta.Labels)) {
+		return false
+	}
+	if len(p.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range p.Namespaces {
+		if ok, _ := path.Match(pattern, meta.Namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func newSettings(cfg Config) (*kleanerSettings, error) {
+	s := &kleanerSettings{
+		dryRun:                  cfg.DryRun,
+		ttlMode:                 cfg.TTLAfterFinishedMode,
+		archiveDir:              cfg.ArchiveDir,
+		deleteTerminatingAfter:  cfg.DeleteTerminatingPodsAfter.Duration,
+		deleteLostNodePodsAfter: cfg.DeleteLostNodePodsAfter.Duration,
+		removableFinalizers:     slices.Clone(cfg.RemovableFinalizers),
+		waitingPodsAnyOwner:     cfg.WaitingPodsAnyOwner,
+		defaults: retention{
+			deleteSuccessfulAfter:   cfg.DeleteSuccessfulAfter.Duration,
+			deleteFailedAfter:       cfg.DeleteFailedAfter.Duration,
+			deletePendingAfter:      cfg.DeletePendingPodsAfter.Duration,
+			deleteOrphanedAfter:     cfg.DeleteOrphanedPodsAfter.Duration,
+			deleteEvictedAfter:      cfg.DeleteEvictedPodsAfter.Duration,
+			deleteCreatingAfter:     cfg.DeleteCreatingPodsAfter.Duration,
+			deleteInitializingAfter: cfg.DeleteInitializingPodsAfter.Duration,
+			deleteWaitingAfter:      reasonDurations(cfg.DeleteWaitingPodsAfter),
+			deleteTerminatedAfter:   reasonDurations(cfg.DeleteTerminatedPodsAfter),
+			ignoreOwnedByCronjob:    cfg.IgnoreOwnedByCronjobs,
+			keepLastSuccessful:      cfg.KeepLastSuccessfulJobs,
+			keepLastFailed:          cfg.KeepLastFailedJobs,
+		},
+	}
+	if d := cfg.DeleteTerminatingPodsAfter.Duration; d != 0 && d < minForceDeleteAfter {
+		return nil, fmt.Errorf("deleteTerminatingPodsAfter has to be at least %s", minForceDeleteAfter)
+	}
+	if d := cfg.DeleteLostNodePodsAfter.Duration; d != 0 && d < minForceDeleteAfter {
+		return nil, fmt.Errorf("deleteLostNodePodsAfter has to be at least %s", minForceDeleteAfter)
+	}
+	if _, ok := cfg.DeleteTerminatedPodsAfter[evictedReason]; ok {
+		return nil, fmt.Errorf("deleteTerminatedPodsAfter can not list %s pods, use deleteEvictedPodsAfter instead", evictedReason)
+	}
+	excluded, err := newExclusions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.exclusions = excluded
+	for i, p := range cfg.Policies {
+		for _, pattern := range p.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("policy %d (%s): invalid namespace pattern '%s': %v", i, p.Name, pattern, err)
+			}
+		}
+		selector := labels.Everything()
+		if p.Selector != nil {
+			var err error
+			selector, err = metav1.LabelSelectorAsSelector(p.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("policy %d (%s): invalid selector: %v", i, p.Name, err)
+			}
+		}
+		var condition *expression
+		if p.Condition != "" {
+			var err error
+			condition, err = newExpression(p.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("policy %d (%s): %v", i, p.Name, err)
+			}
+		}
+		s.policies = append(s.policies, configPolicy{ConfigPolicy: p, selector: selector, condition: condition})
+	}
+	for i, r := range cfg.Rules {
+		parsed, err := newRule(r, cfg.ArchiveDir)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %v", i, r.Name, err)
+		}
+		s.rules = append(s.rules, parsed)
+	}
+	for i, r := range cfg.Resources {
+		key := resourceKey(r)
+		if _, ok := s.resources[key]; ok {
+			return nil, fmt.Errorf("resource %d (%s): listed more than once", i, key)
+		}
+		parsed, err := newResourceCleanup(r)
+		if err != nil {
+			return nil, fmt.Errorf("resource %d (%s): %v", i, key, err)
+		}
+		if s.resources == nil {
+			s.resources = make(map[string]*resourceCleanup)
+		}
+		s.resources[key] = parsed
+	}
+	return s, nil
+}
+
+// reasonDurations converts durations by reason
+func reasonDurations(durations map[string]metav1.Duration) map[string]time.Duration {
+	if len(durations) == 0 {
+		return nil
+	}
+	result := make(map[string]time.Duration, len(durations))
+	for reason, d := range durations {
+		result[reason] = d.Duration
+	}
+	return result
+}
+
+// jobRule returns the first rule matching the job or nil
+func (s *kleanerSettings) jobRule(job *batchv1.Job) *rule {
+	for i := range s.rules {
+		if s.rules[i].matchesJob(job) {
+			return &s.rules[i]
+		}
+	}
+	return nil
+}
+
+// handledByTTL returns true if the job has spec.ttlSecondsAfterFinished set and the mode does not ignore it
+func (s *kleanerSettings) handledByTTL(job *batchv1.Job) bool {
+	if s.ttlMode != TTLModeDefer && s.ttlMode != TTLModeUse {
+		return false
+	}
+	_, ok := jobTTL(job)
+	return ok
+}
+
+// podRule returns the first rule matching the pod or nil
+func (s *kleanerSettings) podRule(pod *corev1.Pod) *rule {
+	for i := range s.rules {
+		if s.rules[i].matchesPod(pod) {
+			return &s.rules[i]
+		}
+	}
+	return nil
+}
+
+// Validate checks that the configuration can be used by the Kleaner
+func (cfg Config) Validate() error {
+	for _, selector := range []string{cfg.LabelSelector, cfg.JobLabelSelector, cfg.PodLabelSelector} {
+		if _, err := labels.Parse(selector); err != nil {
+			return fmt.Errorf("invalid label selector: %v", err)
+		}
+	}
+	switch cfg.TTLAfterFinishedMode {
+	case "", TTLModeIgnore, TTLModeDefer, TTLModeUse:
+	default:
+		return fmt.Errorf("unknown ttl after finished mode '%s'", cfg.TTLAfterFinishedMode)
+	}
+	if cfg.KeepLastSuccessfulJobs < 0 || cfg.KeepLastFailedJobs < 0 {
+		return fmt.Errorf("number of jobs to keep can not be negative")
+	}
+	if cfg.Workers < 0 {
+		return fmt.Errorf("number of workers can not be negative")
+	}
+	// such pods are Running, so they are never seen when Running pods are skipped
+	if cfg.SkipRunningPods && (len(cfg.DeleteWaitingPodsAfter) > 0 || cfg.DeleteTerminatingPodsAfter.Duration > 0 || cfg.DeleteLostNodePodsAfter.Duration > 0) {
+		return fmt.Errorf("skipRunningPods can not be combined with deleteWaitingPodsAfter, deleteTerminatingPodsAfter or deleteLostNodePodsAfter")
+	}
+	if _, err := newNamespaceScope(cfg); err != nil {
+		return err
+	}
+	_, err := newSettings(cfg)
+	return err
+}
+
+// LoadConfig reads the configuration file on top of base, so settings missing from the file keep their base values
+func LoadConfig(filename string, base Config) (Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Config{}, err
+	}
+	return parseConfig(data, base)
+}
+
+func parseConfig(data []byte, base Config) (Config, error) {
+	cfg := base
+	cfg.Policies = nil
+	cfg.Rules = nil
+	// maps are merged into and slices are decoded over by the decoder, the ones of base must not change
+	cfg.DeleteWaitingPodsAfter = copyDurations(base.DeleteWaitingPodsAfter)
+	cfg.DeleteTerminatedPodsAfter = copyDurations(base.DeleteTerminatedPodsAfter)
+	cfg.Namespaces = slices.Clone(base.Namespaces)
+	cfg.ExcludeNamespaces = slices.Clone(base.ExcludeNamespaces)
+	cfg.Resources = copyResources(base.Resources)
+	cfg.RemovableFinalizers = slices.Clone(base.RemovableFinalizers)
+	cfg.ExcludeOwnedBy = slices.Clone(base.ExcludeOwnedBy)
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func copyDurations(durations map[string]metav1.Duration) map[string]metav1.Duration {
+	if durations == nil {
+		return nil
+	}
+	result := make(map[string]metav1.Duration, len(durations))
+	for key, d := range durations {
+		result[key] = d
+	}
+	return result
+}
+
+func copyResources(resources []ResourceConfig) []ResourceConfig {
+	result := slices.Clone(resources)
+	for i := range result {
+		if result[i].Condition != nil {
+			condition := *result[i].Condition
+			result[i].Condition = &condition
+		}
+	}
+	return result
+}
+
+// WatchConfig polls the configuration file and calls onChange every time its content changes.
+// Invalid configurations are logged and skipped.
+func WatchConfig(filename string, base Config, stopCh <-chan struct{}, onChange func(Config)) {
+	// ConfigMap volumes are updated by swapping symlinks, so content is compared instead of modification time
+	last, err := os.ReadFile(filename)
+	if err != nil {
+		log.Printf("failed to read config '%s': %v", filename, err)
+	}
+	ticker := time.NewTicker(configPollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				log.Printf("failed to read config '%s': %v", filename, err)
+				continue
+			}
+			if bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+			cfg, err := parseConfig(data, base)
+			if err != nil {
+				log.Printf("ignoring changes of config '%s': %v", filename, err)
+				continue
+			}
+			log.Printf("config '%s' changed, reloading", filename)
+			onChange(cfg)
+		}
+	}
+}
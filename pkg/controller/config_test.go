@@ -0,0 +1,278 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseConfig(t *testing.T) {
+	base := Config{
+		Namespace:             "default",
+		DeleteSuccessfulAfter: metav1.Duration{Duration: 15 * time.Minute},
+		DeleteFailedAfter:     metav1.Duration{Duration: time.Hour},
+		Policies:              []ConfigPolicy{{Name: "from-base"}},
+	}
+	testCases := map[string]struct {
+		data     string
+		expected Config
+		err      bool
+	}{
+		"missing values are taken from base": {
+			data: `
+deleteFailedAfter: 2h
+dryRun: true
+policies:
+- name: ci
+  namespaces: ["ci-*"]
+  selector:
+    matchLabels:
+      app: tests
+  deleteSuccessfulAfter: 1m
+`,
+			expected: Config{
+				Namespace:             "default",
+				DryRun:                true,
+				DeleteSuccessfulAfter: metav1.Duration{Duration: 15 * time.Minute},
+				DeleteFailedAfter:     metav1.Duration{Duration: 2 * time.Hour},
+				Policies: []ConfigPolicy{
+					{
+						Name:       "ci",
+						Namespaces: []string{"ci-*"},
+						CleanupPolicySpec: CleanupPolicySpec{
+							Selector:              &metav1.LabelSelector{MatchLabels: map[string]string{"app": "tests"}},
+							DeleteSuccessfulAfter: &metav1.Duration{Duration: time.Minute},
+						},
+					},
+				},
+			},
+		},
+		"json is supported": {
+			data: `{"namespace": "", "deleteSuccessfulAfter": "0s"}`,
+			expected: Config{
+				DeleteFailedAfter: metav1.Duration{Duration: time.Hour},
+			},
+		},
+		"unknown fields are rejected": {
+			data: `deleteSuccessfullAfter: 1m`,
+			err:  true,
+		},
+		"invalid namespace patterns are rejected": {
+			data: `{"policies": [{"namespaces": ["ci-["]}]}`,
+			err:  true,
+		},
+		"invalid label selector is rejected": {
+			data: `labelSelector: "app in ("`,
+			err:  true,
+		},
+		"skipping running pods with waiting pod durations is rejected": {
+			data: "skipRunningPods: true\ndeleteWaitingPodsAfter: {CrashLoopBackOff: 1h}",
+			err:  true,
+		},
+		"skipping running pods with terminating pods is rejected": {
+			data: "skipRunningPods: true\ndeleteTerminatingPodsAfter: 1h",
+			err:  true,
+		},
+		"skipping running pods with lost node pods is rejected": {
+			data: "skipRunningPods: true\ndeleteLostNodePodsAfter: 1h",
+			err:  true,
+		},
+		"negative number of workers is rejected": {
+			data: `workers: -1`,
+			err:  true,
+		},
+		"namespace can not be combined with namespaces": {
+			data: "namespace: ci\nnamespaces: [ci, data]",
+			err:  true,
+		},
+		"too short period of terminating pods is rejected": {
+			data: `deleteTerminatingPodsAfter: 10s`,
+			err:  true,
+		},
+		"invalid exclusion selector is rejected": {
+			data: `excludePodSelector: "app in ("`,
+			err:  true,
+		},
+		"evicted pods are rejected in terminated pods": {
+			data: "deleteTerminatedPodsAfter:\n  Evicted: 1h",
+			err:  true,
+		},
+		"resource listed twice is rejected": {
+			data: `
+resources:
+- {group: argoproj.io, version: v1alpha1, resource: workflows, completionTime: "{.status.finishedAt}"}
+- {group: argoproj.io, version: v1alpha1, resource: workflows, completionTime: "{.status.startedAt}"}
+`,
+			err: true,
+		},
+		"invalid excluded namespace pattern is rejected": {
+			data: `excludeNamespaces: ["kube-["]`,
+			err:  true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, err := parseConfig([]byte(tc.data), base)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("failed, expected error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed, unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Fatalf("failed, expected %+v, got %+v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseConfig_reload(t *testing.T) {
+	base := Config{
+		DeleteWaitingPodsAfter:    map[string]metav1.Duration{"CrashLoopBackOff": {Duration: time.Hour}},
+		DeleteTerminatedPodsAfter: map[string]metav1.Duration{"NodeShutdown": {Duration: time.Hour}},
+		Namespaces:                []string{"ci", "dev"},
+		ExcludeNamespaces:         []string{"kube-system", "kube-public"},
+		RemovableFinalizers:       []string{"a", "b"},
+		ExcludeOwnedBy:            []string{"CronJob", "DaemonSet"},
+		Resources: []ResourceConfig{{
+			Version:        "v1alpha1",
+			Resource:       "workflows",
+			CompletionTime: "{.status.finishedAt}",
+			Condition:      &ResourceCondition{Type: "Completed"},
+		}},
+	}
+	expectedBase := base
+	expectedBase.Namespaces = []string{"ci", "dev"}
+	expectedBase.ExcludeNamespaces = []string{"kube-system", "kube-public"}
+	expectedBase.RemovableFinalizers = []string{"a", "b"}
+	expectedBase.ExcludeOwnedBy = []string{"CronJob", "DaemonSet"}
+	expectedBase.Resources = []ResourceConfig{{
+		Version:        "v1alpha1",
+		Resource:       "workflows",
+		CompletionTime: "{.status.finishedAt}",
+		Condition:      &ResourceCondition{Type: "Completed"},
+	}}
+	lists := "namespaces: [qa]\nexcludeNamespaces: [tmp]\nremovableFinalizers: [x]\nexcludeOwnedBy: [StatefulSet]\n" +
+		"resources: [{version: v1, resource: pipelineruns, completionTime: '{.status.completionTime}', condition: {type: Succeeded}}]"
+	if _, err := parseConfig([]byte(lists), base); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if _, err := parseConfig([]byte("deleteWaitingPodsAfter: {ImagePullBackOff: 10m}\ndeleteTerminatedPodsAfter: {Preempting: 10m}"), base); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	result, err := parseConfig([]byte("deleteWaitingPodsAfter: {ErrImagePull: 5m}\ndeleteTerminatedPodsAfter: {Shutdown: 5m}"), base)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	expected := map[string]metav1.Duration{"CrashLoopBackOff": {Duration: time.Hour}, "ErrImagePull": {Duration: 5 * time.Minute}}
+	if !reflect.DeepEqual(result.DeleteWaitingPodsAfter, expected) {
+		t.Fatalf("failed, expected %v, got %v", expected, result.DeleteWaitingPodsAfter)
+	}
+	expected = map[string]metav1.Duration{"NodeShutdown": {Duration: time.Hour}, "Shutdown": {Duration: 5 * time.Minute}}
+	if !reflect.DeepEqual(result.DeleteTerminatedPodsAfter, expected) {
+		t.Fatalf("failed, expected %v, got %v", expected, result.DeleteTerminatedPodsAfter)
+	}
+	if len(base.DeleteWaitingPodsAfter) != 1 || len(base.DeleteTerminatedPodsAfter) != 1 {
+		t.Fatalf("failed, base is changed: %v %v", base.DeleteWaitingPodsAfter, base.DeleteTerminatedPodsAfter)
+	}
+	if !reflect.DeepEqual(base.Namespaces, expectedBase.Namespaces) || !reflect.DeepEqual(base.ExcludeNamespaces, expectedBase.ExcludeNamespaces) ||
+		!reflect.DeepEqual(base.RemovableFinalizers, expectedBase.RemovableFinalizers) || !reflect.DeepEqual(base.Resources, expectedBase.Resources) ||
+		!reflect.DeepEqual(base.ExcludeOwnedBy, expectedBase.ExcludeOwnedBy) {
+		t.Fatalf("failed, base lists are changed: %v %v %v %v %+v", base.Namespaces, base.ExcludeNamespaces, base.RemovableFinalizers, base.ExcludeOwnedBy, base.Resources[0])
+	}
+	if !reflect.DeepEqual(result.RemovableFinalizers, expectedBase.RemovableFinalizers) || !reflect.DeepEqual(result.ExcludeOwnedBy, expectedBase.ExcludeOwnedBy) {
+		t.Fatalf("failed, expected lists of base after the keys are dropped, got %v %v", result.RemovableFinalizers, result.ExcludeOwnedBy)
+	}
+}
+
+func TestConfigPolicy_matches(t *testing.T) {
+	current, err := newSettings(Config{Policies: []ConfigPolicy{
+		{
+			Namespaces: []string{"ci-*", "tests"},
+			CleanupPolicySpec: CleanupPolicySpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "tests"}},
+			},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create settings: %v", err)
+	}
+	policy := current.policies[0]
+	testCases := map[string]struct {
+		meta     metav1.ObjectMeta
+		expected bool
+	}{
+		"namespace matching the pattern": {
+			meta:     metav1.ObjectMeta{Namespace: "ci-1", Labels: map[string]string{"app": "tests"}},
+			expected: true,
+		},
+		"namespace matching exactly": {
+			meta:     metav1.ObjectMeta{Namespace: "tests", Labels: map[string]string{"app": "tests"}},
+			expected: true,
+		},
+		"namespace not matching": {
+			meta:     metav1.ObjectMeta{Namespace: "default", Labels: map[string]string{"app": "tests"}},
+			expected: false,
+		},
+		"labels not matching": {
+			meta:     metav1.ObjectMeta{Namespace: "ci-1", Labels: map[string]string{"app": "web"}},
+			expected: false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := policy.matches(&tc.meta)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	base := Config{Namespaces: []string{"ci"}, Workers: 2, DeleteSuccessfulAfter: metav1.Duration{Duration: time.Hour}}
+	testCases := map[string]struct {
+		update   func(cfg *Config)
+		expected bool
+	}{
+		"durations are applied": {
+			update: func(cfg *Config) { cfg.DeleteSuccessfulAfter.Duration = time.Minute },
+		},
+		"namespaces require restart": {
+			update:   func(cfg *Config) { cfg.Namespaces = append(cfg.Namespaces, "dev") },
+			expected: true,
+		},
+		"excluded namespaces require restart": {
+			update:   func(cfg *Config) { cfg.ExcludeNamespaces = []string{"kube-*"} },
+			expected: true,
+		},
+		"namespace selector requires restart": {
+			update:   func(cfg *Config) { cfg.NamespaceSelector = "team=ci" },
+			expected: true,
+		},
+		"skipping running pods requires restart": {
+			update:   func(cfg *Config) { cfg.SkipRunningPods = true },
+			expected: true,
+		},
+		"workers require restart": {
+			update:   func(cfg *Config) { cfg.Workers = 4 },
+			expected: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := base
+			cfg.Namespaces = append([]string(nil), base.Namespaces...)
+			tc.update(&cfg)
+			result := restartRequired(base, cfg)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
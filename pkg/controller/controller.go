@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
@@ -12,10 +15,15 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/lwolf/kube-cleanup-operator/pkg/shard"
 )
 
 func ignoreNotFound(err error) error {
@@ -35,184 +43,549 @@ const (
 	podDeletedFailedMetric = "pods_deleted_failed_total"
 	jobDeletedFailedMetric = "jobs_deleted_failed_total"
 	jobDeletedMetric       = "jobs_deleted_total"
+	// jobDeletedByTTLMetric counts finished jobs with expired ttlSecondsAfterFinished deleted by someone else,
+	// i.e. the TTL-after-finished controller
+	jobDeletedByTTLMetric = "jobs_deleted_by_ttl_controller_total"
+	// pendingPodDeletedMetric counts deleted Pending pods by their state, see pendingState
+	pendingPodDeletedMetric = "pending_pods_deleted_total"
+	// failedPodDeletedMetric counts deleted Failed pods by their status reason, e.g. Evicted or NodeShutdown
+	failedPodDeletedMetric = "failed_pods_deleted_total"
+	// shardNamespacesMetric is the number of namespaces assigned to the replica, shardMembersMetric the number of replicas
+	shardNamespacesMetric = "shard_namespaces"
+	shardMembersMetric    = "shard_members"
 )
 
 // Kleaner watches the kubernetes api for changes to Pods and Jobs and
-// delete those according to configured timeouts
+// delete those according to configured timeouts. Every object is put into a delaying queue
+// for the moment its threshold elapses instead of rescanning the informer stores.
+// The queue is drained by a pool of workers taking objects of different namespaces in turns.
 type Kleaner struct {
-	podInformer cache.SharedIndexInformer
-	jobInformer cache.SharedIndexInformer
-	kclient     *kubernetes.Clientset
+	kclient *kubernetes.Clientset
+	dclient dynamic.Interface
+	queue   workqueue.RateLimitingInterface
+
+	// informers holds the pod and job informers by namespace. When the scope is the whole cluster, minus excluded
+	// namespaces, or a single namespace there is one entry under the empty key, otherwise an entry per namespace
+	// watched by the replica.
+	informersMu sync.RWMutex
+	informers   map[string]*objectInformers
+	// scope is nil unless namespaces are listed, excluded or selected by labels,
+	// members is nil unless namespaces are sharded across replicas
+	scope   *namespaceScope
+	members *shard.Members
+	// scopeNamespaceInformer lists the namespaces matched against the scope and assigned to replicas,
+	// nil if neither is needed or the scope is an explicit list of namespaces
+	scopeNamespaceInformer cache.SharedIndexInformer
+	// namespacesSynced is set by Run once the scope namespaces and members are synced,
+	// namespace events before it are ignored
+	namespacesSynced atomic.Bool
 
-	deleteSuccessfulAfter time.Duration
-	deleteFailedAfter     time.Duration
-	deletePendingAfter    time.Duration
-	deleteOrphanedAfter   time.Duration
-	deleteEvictedAfter    time.Duration
+	// policy informers are nil when CleanupPolicy support is disabled,
+	// cluster policies are not watched when the scope is limited to a single namespace
+	policyInformer        cache.SharedIndexInformer
+	clusterPolicyInformer cache.SharedIndexInformer
+	// namespaceInformer is nil unless namespace annotations are allowed to override the defaults
+	namespaceInformer cache.SharedIndexInformer
+	// nodeInformer is nil unless pods of lost nodes are deleted,
+	// deletedNodes holds the time nodes were deleted at, or first missed, by name
+	nodeInformer cache.SharedIndexInformer
+	deletedNodes sync.Map
 
-	ignoreOwnedByCronjob bool
-	
-	labelSelector        string
+	// config the Kleaner was started with, only the settings can be changed later
+	config   Config
+	settings atomic.Pointer[kleanerSettings]
+
+	// deletedJobs holds UIDs of jobs deleted by the Kleaner until their deletion is observed,
+	// to tell them apart from jobs deleted by the TTL controller
+	deletedJobs sync.Map
 
-	dryRun bool
 	ctx    context.Context
 	stopCh <-chan struct{}
 }
 
-// NewKleaner creates a new NewKleaner
-func NewKleaner(ctx context.Context, kclient *kubernetes.Clientset, namespace string, dryRun bool, deleteSuccessfulAfter,
-	deleteFailedAfter, deletePendingAfter, deleteOrphanedAfter, deleteEvictedAfter time.Duration, ignoreOwnedByCronjob bool,
-	labelSelector string,
-	stopCh <-chan struct{}) *Kleaner {
-	jobInformer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{
-			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				options.LabelSelector = labelSelector
-				return kclient.BatchV1().Jobs(namespace).List(ctx, options)
-			},
-			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-				options.LabelSelector = labelSelector
-				return kclient.BatchV1().Jobs(namespace).Watch(ctx, options)
-			},
-		},
-		&batchv1.Job{},
-		resyncPeriod,
-		cache.Indexers{},
-	)
-	// Create informer for watching Namespaces
-	podInformer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{
-			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				options.LabelSelector = labelSelector
-				return kclient.CoreV1().Pods(namespace).List(ctx, options)
+// NewKleaner creates a new NewKleaner. ClusterCleanupPolicy objects are watched only
+// when the scope is not limited to a single namespace. When members is not nil, only the objects
+// of namespaces in the scope assigned to the replica are watched.
+func NewKleaner(ctx context.Context, kclient *kubernetes.Clientset, dclient dynamic.Interface, cfg Config, members *shard.Members, stopCh <-chan struct{}) *Kleaner {
+	namespace := cfg.Namespace
+	current, err := newSettings(cfg)
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	scope, err := newNamespaceScope(cfg)
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if dclient == nil && (cfg.EnableCleanupPolicies || len(cfg.Resources) > 0) {
+		log.Fatalf("Invalid config: cleanup policies and custom resources require the dynamic client")
+	}
+	kleaner := &Kleaner{
+		kclient:   kclient,
+		dclient:   dclient,
+		informers: make(map[string]*objectInformers),
+		scope:     scope,
+		members:   members,
+		queue: workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{
+			DelayingQueue: workqueue.NewDelayingQueueWithConfig(workqueue.DelayingQueueConfig{Name: "kleaner", Queue: newFairQueue()}),
+		}),
+		config: cfg,
+		ctx:    ctx,
+		stopCh: stopCh,
+	}
+	kleaner.settings.Store(current)
+	// changes of policies and namespaces affect the thresholds of every object they apply to
+	if cfg.EnableCleanupPolicies {
+		kleaner.policyInformer = newPolicyInformer(dclient, cleanupPolicyResource, namespace)
+		kleaner.policyInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    kleaner.enqueueNamespaceOf,
+			UpdateFunc: func(old, new interface{}) { kleaner.enqueueNamespaceOf(new) },
+			DeleteFunc: kleaner.enqueueNamespaceOf,
+		})
+		if namespace == metav1.NamespaceAll {
+			kleaner.clusterPolicyInformer = newPolicyInformer(dclient, clusterCleanupPolicyResource, metav1.NamespaceAll)
+			kleaner.clusterPolicyInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc:    func(interface{}) { kleaner.enqueueAll() },
+				UpdateFunc: func(old, new interface{}) { kleaner.enqueueAll() },
+				DeleteFunc: func(interface{}) { kleaner.enqueueAll() },
+			})
+		}
+	}
+	if cfg.EnableNamespaceOverrides {
+		kleaner.namespaceInformer = newNamespaceInformer(ctx, kclient, namespace)
+		kleaner.namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				if ns := new.(*corev1.Namespace); !reflect.DeepEqual(old.(*corev1.Namespace).Annotations, ns.Annotations) {
+					kleaner.enqueueNamespace(ns.Name)
+				}
 			},
-			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-				options.LabelSelector = labelSelector
-				return kclient.CoreV1().Pods(namespace).Watch(ctx, options)
+		})
+	}
+	// the node informer has to be set before the pod informers are created, they index pods by node
+	if cfg.DeleteLostNodePodsAfter.Duration != 0 {
+		kleaner.nodeInformer = newNodeInformer(ctx, kclient)
+		if err := kleaner.nodeInformer.SetTransform(transformObject); err != nil {
+			log.Fatalf("failed to set transform of the node informer: %v", err)
+		}
+		kleaner.nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { kleaner.deletedNodes.Delete(obj.(*corev1.Node).Name) },
+			UpdateFunc: kleaner.nodeChanged,
+			DeleteFunc: kleaner.nodeDeleted,
+		})
+	}
+	if !kleaner.watchesNamespaces() {
+		kleaner.informers[metav1.NamespaceAll] = kleaner.newObjectInformers(namespace)
+		return kleaner
+	}
+	if members != nil || !scope.static() {
+		// the namespace informer of the overrides is shared if enabled
+		kleaner.scopeNamespaceInformer = kleaner.namespaceInformer
+		if kleaner.scopeNamespaceInformer == nil {
+			kleaner.scopeNamespaceInformer = newNamespaceInformer(ctx, kclient, namespace)
+		}
+		kleaner.scopeNamespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) { kleaner.syncNamespaces() },
+			UpdateFunc: func(old, new interface{}) {
+				// labels select namespaces and may assign them to another replica
+				if !reflect.DeepEqual(old.(*corev1.Namespace).Labels, new.(*corev1.Namespace).Labels) {
+					kleaner.syncNamespaces()
+				}
 			},
-		},
-		&corev1.Pod{},
-		resyncPeriod,
-		cache.Indexers{},
-	)
-	kleaner := &Kleaner{
-		dryRun:                dryRun,
-		kclient:               kclient,
-		ctx:                   ctx,
-		stopCh:                stopCh,
-		deleteSuccessfulAfter: deleteSuccessfulAfter,
-		deleteFailedAfter:     deleteFailedAfter,
-		deletePendingAfter:    deletePendingAfter,
-		deleteOrphanedAfter:   deleteOrphanedAfter,
-		deleteEvictedAfter:    deleteEvictedAfter,
-		ignoreOwnedByCronjob:  ignoreOwnedByCronjob,
-		labelSelector:         labelSelector,
-	}
-	jobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		UpdateFunc: func(old, new interface{}) {
-			if !reflect.DeepEqual(old, new) {
-				kleaner.Process(new)
-			}
-		},
+			DeleteFunc: func(interface{}) { kleaner.syncNamespaces() },
+		})
+	}
+	if members == nil {
+		return kleaner
+	}
+	members.OnChange(kleaner.syncNamespaces)
+	metrics.GetOrCreateGauge(shardNamespacesMetric, func() float64 {
+		kleaner.informersMu.RLock()
+		defer kleaner.informersMu.RUnlock()
+		return float64(len(kleaner.informers))
 	})
-	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		UpdateFunc: func(old, new interface{}) {
-			if !reflect.DeepEqual(old, new) {
-				kleaner.Process(new)
-			}
-		},
+	metrics.GetOrCreateGauge(shardMembersMetric, func() float64 {
+		return float64(members.Size())
 	})
-
-	kleaner.podInformer = podInformer
-	kleaner.jobInformer = jobInformer
-
 	return kleaner
 }
 
-func (c *Kleaner) periodicCacheCheck() {
-	ticker := time.NewTicker(2 * resyncPeriod)
-	for {
-		select {
-		case <-c.stopCh:
-			ticker.Stop()
-			return
-		case <-ticker.C:
-			for _, job := range c.jobInformer.GetStore().List() {
-				c.Process(job)
-			}
-			for _, obj := range c.podInformer.GetStore().List() {
-				c.Process(obj)
-			}
-		}
-	}
+// watchesNamespaces returns true if objects are watched by an informer per namespace: namespaces are sharded, listed
+// or selected by labels. Otherwise they are watched by cluster-wide informers.
+func (c *Kleaner) watchesNamespaces() bool {
+	return c.members != nil || (c.scope != nil && !c.scope.excludeOnly())
 }
 
 // Run starts the process for listening for pod changes and acting upon those changes.
 func (c *Kleaner) Run() {
 	log.Printf("Listening for changes...")
 
-	go c.podInformer.Run(c.stopCh)
-	go c.jobInformer.Run(c.stopCh)
+	// policies and namespaces have to be known before the first object is processed,
+	// otherwise objects could be deleted using the default thresholds
+	var overridesSynced []cache.InformerSynced
+	for _, informer := range []cache.SharedIndexInformer{c.policyInformer, c.clusterPolicyInformer, c.namespaceInformer, c.nodeInformer} {
+		if informer != nil {
+			go informer.Run(c.stopCh)
+			overridesSynced = append(overridesSynced, informer.HasSynced)
+		}
+	}
+	if c.members != nil {
+		go c.members.Run(c.stopCh)
+		overridesSynced = append(overridesSynced, c.members.HasSynced)
+	}
+	if c.scopeNamespaceInformer != nil && c.scopeNamespaceInformer != c.namespaceInformer {
+		go c.scopeNamespaceInformer.Run(c.stopCh)
+		overridesSynced = append(overridesSynced, c.scopeNamespaceInformer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(c.stopCh, overridesSynced...) {
+		log.Printf("failed to sync cleanup policies, namespaces and nodes")
+		return
+	}
 
-	go c.periodicCacheCheck()
+	defer c.queue.ShutDown()
+	defer c.stopInformers()
+	if c.watchesNamespaces() {
+		// informers of the namespaces are started as they enter the scope,
+		// their objects are not processed until they are synced
+		c.namespacesSynced.Store(true)
+		c.syncNamespaces()
+	} else {
+		informers := c.informersOf(metav1.NamespaceAll)
+		informers.run()
+		// pods are processed together with their jobs, so both have to be known before the first object is processed
+		if !cache.WaitForCacheSync(c.stopCh, informers.hasSynced) {
+			log.Printf("failed to sync pods and jobs")
+			return
+		}
+	}
+
+	workers := c.config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go c.runWorker()
+	}
 
 	<-c.stopCh
 }
 
-func (c *Kleaner) Process(obj interface{}) {
+// Process deletes the object if its threshold has elapsed. Otherwise it returns the time the object
+// has to be processed again, zero if it only has to be processed when it changes.
+func (c *Kleaner) Process(obj interface{}) (time.Time, error) {
 	switch t := obj.(type) {
 	case *batchv1.Job:
 		// skip jobs that are already in the deleting process
 		if !t.DeletionTimestamp.IsZero() {
-			return
+			return time.Time{}, nil
+		}
+		current := c.settings.Load()
+		if current.exclusions.excludesJob(t) {
+			return time.Time{}, nil
+		}
+		// skip jobs protected by annotations
+		if isProtected(t.Annotations, time.Now()) {
+			return keepUntil(t.Annotations), nil
 		}
-		if shouldDeleteJob(t, c.deleteSuccessfulAfter, c.deleteFailedAfter, c.ignoreOwnedByCronjob) {
-			c.DeleteJob(t)
+		// the first matching rule decides, the rest of the flow is skipped
+		if rule := current.jobRule(t); rule != nil {
+			expiry, ok := rule.expiry(jobPhase(t), jobReferenceTime(t))
+			return deleteWhenExpired(expiry, ok, func() error {
+				get := func() (runtime.Object, error) {
+					return c.kclient.BatchV1().Jobs(t.Namespace).Get(c.ctx, t.Name, metav1.GetOptions{})
+				}
+				if err := c.archiveIfRequired(rule, batchv1.SchemeGroupVersion.WithKind("Job"), &t.ObjectMeta, get); err != nil {
+					return err
+				}
+				return c.DeleteJob(t)
+			})
 		}
+		// ttlSecondsAfterFinished is left to the TTL controller in defer mode and replaces the durations in use mode
+		if current.handledByTTL(t) {
+			if current.ttlMode != TTLModeUse {
+				return time.Time{}, nil
+			}
+			ttl, _ := jobTTL(t)
+			expiry, ok := jobTTLExpiry(t, ttl)
+			return deleteWhenExpired(expiry, ok, func() error { return c.DeleteJob(t) })
+		}
+		r := c.retentionFor(&t.ObjectMeta)
+		if r.ignoreOwnedByCronjob && isOwnedByCronJob(getJobOwnerKinds(t)) {
+			return time.Time{}, nil
+		}
+		// count based retention replaces the durations for the outcomes it is enabled for,
+		// jobs are processed again when other jobs of the group finish
+		if counted, remove := c.jobHistoryDecision(t, r); counted {
+			if remove && r.condition.matches(t) {
+				return time.Time{}, c.DeleteJob(t)
+			}
+			return time.Time{}, nil
+		}
+		expiry, ok := jobExpiry(t, r.deleteSuccessfulAfter, r.deleteFailedAfter, r.ignoreOwnedByCronjob)
+		return deleteWhenExpired(expiry, ok, func() error {
+			if !r.condition.matches(t) {
+				return nil
+			}
+			return c.DeleteJob(t)
+		})
 	case *corev1.Pod:
 		pod := t
-		// skip pods that are already in the deleting process
-		if !pod.DeletionTimestamp.IsZero() {
-			return
+		current := c.settings.Load()
+		// skip pods that are already in the deleting process, unless the ones stuck in it are force deleted
+		terminating := !pod.DeletionTimestamp.IsZero()
+		if terminating && current.deleteTerminatingAfter == 0 && current.deleteLostNodePodsAfter == 0 {
+			return time.Time{}, nil
+		}
+		informers := c.informersOf(pod.Namespace)
+		if informers == nil {
+			// the namespace has been assigned to another replica
+			return time.Time{}, nil
+		}
+		jobs := informers.jobs.GetStore()
+		job := getPodOwnerJob(pod, jobs)
+		// when jobs are selected by their own labels, pods follow their job, the ones of jobs out of the scope are skipped
+		if job == nil && c.config.JobLabelSelector != "" && isOwnedByJob(getPodOwnerKinds(pod)) {
+			return time.Time{}, nil
+		}
+		if current.exclusions.excludesPod(pod, job) {
+			return time.Time{}, nil
+		}
+		// skip pods protected by annotations, either their own or the ones of the owning job
+		now := time.Now()
+		if isProtected(pod.Annotations, now) {
+			return keepUntil(pod.Annotations), nil
+		}
+		if job != nil && isProtected(job.Annotations, now) {
+			return keepUntil(job.Annotations), nil
+		}
+		// pods of lost nodes are force deleted whatever their phase, the kubelet never reports it again
+		if c.nodeInformer != nil {
+			if expiry, ok := c.lostNodePodExpiry(pod, current.deleteLostNodePodsAfter, current.removableFinalizers); ok {
+				// the pod may be stuck in Terminating for long enough already
+				if stuckExpiry, stuck := terminatingPodExpiry(pod, current.deleteTerminatingAfter, current.removableFinalizers); stuck && stuckExpiry.Before(expiry) {
+					expiry = stuckExpiry
+				}
+				return deleteWhenExpired(expiry, ok, func() error {
+					return c.forceDeletePod(pod, lostNodePodDeletedMetric, lostNodePodDeletedFailedMetric)
+				})
+			}
 		}
+		if terminating {
+			expiry, ok := terminatingPodExpiry(pod, current.deleteTerminatingAfter, current.removableFinalizers)
+			return deleteWhenExpired(expiry, ok, func() error {
+				return c.forceDeletePod(pod, podForceDeletedMetric, podForceDeletedFailedMetric)
+			})
+		}
+		// the first matching rule decides, the rest of the flow is skipped
+		if rule := current.podRule(pod); rule != nil {
+			expiry, ok := rule.expiry(string(pod.Status.Phase), podReferenceTime(pod))
+			return deleteWhenExpired(expiry, ok, func() error {
+				get := func() (runtime.Object, error) {
+					return c.kclient.CoreV1().Pods(pod.Namespace).Get(c.ctx, pod.Name, metav1.GetOptions{})
+				}
+				if err := c.archiveIfRequired(rule, corev1.SchemeGroupVersion.WithKind("Pod"), &pod.ObjectMeta, get); err != nil {
+					return err
+				}
+				return c.DeletePod(pod)
+			})
+		}
+		// pods of jobs handled by ttl are deleted together with the job
+		if job != nil && current.handledByTTL(job) {
+			return time.Time{}, nil
+		}
+		r := c.retentionFor(&pod.ObjectMeta)
 		// skip pods related to jobs created by cronjobs if `ignoreOwnedByCronjob` is set
-		if c.ignoreOwnedByCronjob && podRelatedToCronJob(pod, c.jobInformer.GetStore()) {
-			return
+		if r.ignoreOwnedByCronjob && podRelatedToCronJob(pod, jobs) {
+			return time.Time{}, nil
+		}
+		// pods inherit the annotations of their job, pod's own annotations are applied in podExpiry
+		if job != nil {
+			// pods of jobs retained by count are kept together with their job, the rest goes with the job
+			if counted, _ := c.jobHistoryDecision(job, c.retentionFor(&job.ObjectMeta)); counted {
+				return time.Time{}, nil
+			}
+			r = r.withAnnotations(job.Annotations)
+		}
+		// evicted and terminated pods carrying no timestamp of it are annotated with the time they were first seen,
+		// they are processed again once the annotation shows up
+		if r.terminationDuration(pod) > 0 && podEvictionTime(pod).IsZero() && !current.dryRun {
+			return time.Time{}, c.recordEviction(pod)
 		}
 		// normal cleanup flow
-		if shouldDeletePod(t, c.deleteOrphanedAfter, c.deletePendingAfter, c.deleteEvictedAfter, c.deleteSuccessfulAfter, c.deleteFailedAfter) {
-			c.DeletePod(t)
+		expiry, ok := podExpiry(pod, r.deleteOrphanedAfter, r.deletePendingAfter, r.deleteEvictedAfter, r.deleteSuccessfulAfter, r.deleteFailedAfter)
+		if pendingExpiry, pending := pendingPodExpiry(pod, r.deleteCreatingAfter, r.deleteInitializingAfter); pending && (!ok || pendingExpiry.Before(expiry)) {
+			expiry, ok = pendingExpiry, true
+		}
+		if waitingExpiry, waiting := waitingPodExpiry(pod, r.deleteWaitingAfter, current.waitingPodsAnyOwner); waiting && (!ok || waitingExpiry.Before(expiry)) {
+			expiry, ok = waitingExpiry, true
 		}
+		if terminatedExpiry, terminated := terminatedPodExpiry(pod, r.deleteTerminatedAfter); terminated && (!ok || terminatedExpiry.Before(expiry)) {
+			expiry, ok = terminatedExpiry, true
+		}
+		return deleteWhenExpired(expiry, ok, func() error {
+			if !r.condition.matches(pod) {
+				return nil
+			}
+			return c.DeletePod(pod)
+		})
 	}
+	return time.Time{}, nil
 }
 
-func (c *Kleaner) DeleteJob(job *batchv1.Job) {
-	if c.dryRun {
-		log.Printf("dry-run: Job '%s:%s' would have been deleted", job.Namespace, job.Name)
+// deleteWhenExpired calls remove if the expiry time has passed, otherwise returns the expiry time.
+// ok is false for objects that must not be deleted in their current state.
+func deleteWhenExpired(expiry time.Time, ok bool, remove func() error) (time.Time, error) {
+	if !ok {
+		return time.Time{}, nil
+	}
+	if time.Now().Before(expiry) {
+		return expiry, nil
+	}
+	return time.Time{}, remove()
+}
+
+// archiveIfRequired archives the object if the action of the rule requires it. Objects of the informers are trimmed,
+// so the whole object is read with get first. Returns an error if the object could not be archived and must not be deleted.
+func (c *Kleaner) archiveIfRequired(rule *rule, gvk schema.GroupVersionKind, meta *metav1.ObjectMeta, get func() (runtime.Object, error)) error {
+	if rule.Action.Type != RuleActionArchive {
+		return nil
+	}
+	current := c.settings.Load()
+	if current.dryRun {
+		log.Printf("dry-run: %s '%s:%s' would have been archived", gvk.Kind, meta.Namespace, meta.Name)
+		return nil
+	}
+	obj, err := get()
+	if err == nil && obj.(metav1.Object).GetUID() != meta.UID {
+		err = fmt.Errorf("it was replaced by another object")
+	}
+	if err == nil {
+		err = archiveObject(current.archiveDir, obj, gvk, meta)
+	}
+	if err != nil {
+		log.Printf("failed to archive %s '%s:%s', it is not deleted: %v", gvk.Kind, meta.Namespace, meta.Name, err)
+		return err
+	}
+	return nil
+}
+
+// restartRequired returns true if settings the informers, the namespace scope or the workers are created with changed
+func restartRequired(old, cfg Config) bool {
+	return cfg.Namespace != old.Namespace || !slices.Equal(cfg.Namespaces, old.Namespaces) ||
+		!slices.Equal(cfg.ExcludeNamespaces, old.ExcludeNamespaces) || cfg.NamespaceSelector != old.NamespaceSelector ||
+		cfg.LabelSelector != old.LabelSelector || cfg.JobLabelSelector != old.JobLabelSelector || cfg.PodLabelSelector != old.PodLabelSelector ||
+		cfg.SkipRunningPods != old.SkipRunningPods || cfg.Workers != old.Workers || cfg.JobHistoryGroupLabel != old.JobHistoryGroupLabel ||
+		(cfg.DeleteLostNodePodsAfter.Duration == 0) != (old.DeleteLostNodePodsAfter.Duration == 0) ||
+		cfg.EnableCleanupPolicies != old.EnableCleanupPolicies || cfg.EnableNamespaceOverrides != old.EnableNamespaceOverrides ||
+		!reflect.DeepEqual(watchedResources(cfg.Resources), watchedResources(old.Resources))
+}
+
+// UpdateConfig applies the new configuration. Settings that require restarting
+// the informers are ignored if changed.
+func (c *Kleaner) UpdateConfig(cfg Config) {
+	if restartRequired(c.config, cfg) {
+		log.Printf("changes of namespace, namespaces, excludeNamespaces, namespaceSelector, label selectors, skipRunningPods, workers, jobHistoryGroupLabel, enable* settings, watched resources and enabling or disabling deleteLostNodePodsAfter require restart and are ignored")
+	}
+	updated, err := newSettings(cfg)
+	if err != nil {
+		log.Printf("ignoring invalid config: %v", err)
 		return
 	}
+	c.settings.Store(updated)
+	// thresholds could have changed for any object
+	c.enqueueAll()
+}
+
+// retentionFor returns the thresholds for the given object. Overrides are applied on top of the defaults
+// from the least to the most specific: policies from the config in order, the most specific ClusterCleanupPolicy,
+// annotations of the object's namespace and the most specific CleanupPolicy from the object's namespace.
+func (c *Kleaner) retentionFor(meta *metav1.ObjectMeta) retention {
+	current := c.settings.Load()
+	r := current.defaults
+	for i := range current.policies {
+		if current.policies[i].matches(meta) {
+			r = current.policies[i].apply(r)
+		}
+	}
+	lbls := labels.Set(meta.Labels)
+	if c.clusterPolicyInformer != nil {
+		if policy := mostSpecificPolicy(c.clusterPolicyInformer.GetStore().List(), lbls); policy != nil {
+			r = policy.apply(r)
+		}
+	}
+	if c.namespaceInformer != nil {
+		obj, exists, err := c.namespaceInformer.GetStore().GetByKey(meta.Namespace)
+		if err != nil {
+			log.Printf("failed to get namespace '%s': %v", meta.Namespace, err)
+		} else if exists {
+			r = r.withAnnotations(obj.(*corev1.Namespace).Annotations)
+		}
+	}
+	if c.policyInformer != nil {
+		namespaced, err := c.policyInformer.GetIndexer().ByIndex(cache.NamespaceIndex, meta.Namespace)
+		if err != nil {
+			log.Printf("failed to list cleanup policies in '%s': %v", meta.Namespace, err)
+			return r
+		}
+		if policy := mostSpecificPolicy(namespaced, lbls); policy != nil {
+			r = policy.apply(r)
+		}
+	}
+	return r
+}
+
+func (c *Kleaner) DeleteJob(job *batchv1.Job) error {
+	if c.settings.Load().dryRun {
+		log.Printf("dry-run: Job '%s:%s' would have been deleted", job.Namespace, job.Name)
+		return nil
+	}
 	log.Printf("Deleting job '%s/%s'", job.Namespace, job.Name)
 	propagation := metav1.DeletePropagationForeground
 	jo := metav1.DeleteOptions{PropagationPolicy: &propagation}
 	if err := c.kclient.BatchV1().Jobs(job.Namespace).Delete(c.ctx, job.Name, jo); ignoreNotFound(err) != nil {
 		log.Printf("failed to delete job '%s:%s': %v", job.Namespace, job.Name, err)
 		metrics.GetOrCreateCounter(metricName(jobDeletedFailedMetric, job.Namespace)).Inc()
-		return
+		return err
 	}
+	c.deletedJobs.Store(job.UID, struct{}{})
 	metrics.GetOrCreateCounter(metricName(jobDeletedMetric, job.Namespace)).Inc()
+	return nil
 }
 
-func (c *Kleaner) DeletePod(pod *corev1.Pod) {
-	if c.dryRun {
-		log.Printf("dry-run: Pod '%s:%s' would have been deleted", pod.Namespace, pod.Name)
+// jobDeleted counts jobs deleted by the TTL controller, i.e. finished jobs with expired ttlSecondsAfterFinished
+// that were not deleted by the Kleaner
+func (c *Kleaner) jobDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
 		return
 	}
+	if _, deletedByKleaner := c.deletedJobs.LoadAndDelete(job.UID); deletedByKleaner {
+		return
+	}
+	if ttl, ok := jobTTL(job); ok && shouldDeleteJobByTTL(job, ttl) {
+		metrics.GetOrCreateCounter(metricName(jobDeletedByTTLMetric, job.Namespace)).Inc()
+	}
+}
+
+func (c *Kleaner) DeletePod(pod *corev1.Pod) error {
+	if c.settings.Load().dryRun {
+		log.Printf("dry-run: Pod '%s:%s' would have been deleted", pod.Namespace, pod.Name)
+		return nil
+	}
 	log.Printf("Deleting pod '%s/%s'", pod.Namespace, pod.Name)
 	var po metav1.DeleteOptions
 	if err := c.kclient.CoreV1().Pods(pod.Namespace).Delete(c.ctx, pod.Name, po); ignoreNotFound(err) != nil {
 		log.Printf("failed to delete pod '%s:%s': %v", pod.Namespace, pod.Name, err)
 		metrics.GetOrCreateCounter(metricName(podDeletedFailedMetric, pod.Namespace)).Inc()
-		return
+		return err
 	}
 	metrics.GetOrCreateCounter(metricName(podDeletedMetric, pod.Namespace)).Inc()
+	if state, _ := pendingState(pod); state != "" {
+		metrics.GetOrCreateCounter(fmt.Sprintf(`%s{namespace=%q,reason=%q}`, pendingPodDeletedMetric, pod.Namespace, state)).Inc()
+	}
+	if pod.Status.Phase == corev1.PodFailed && pod.Status.Reason != "" {
+		metrics.GetOrCreateCounter(fmt.Sprintf(`%s{namespace=%q,reason=%q}`, failedPodDeletedMetric, pod.Namespace, pod.Status.Reason)).Inc()
+	}
+	return nil
 }
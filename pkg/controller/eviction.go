@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// evictedReason is the status reason of pods evicted by the kubelet or the eviction API
+const evictedReason = "Evicted"
+
+// evictedAtAnnotation is set by the operator to the RFC3339 time it first observed the eviction of a pod,
+// or its termination by the node, if the pod carries no other timestamp of it
+const evictedAtAnnotation = AnnotationPrefix + "evicted-at"
+
+func isEvicted(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodFailed && pod.Status.Reason == evictedReason
+}
+
+// podEvictionTime returns the time the pod was evicted or terminated by the node at, zero if it is not known.
+// The DisruptionTarget condition set by the eviction manager or the node shutdown manager is preferred,
+// then the time the last container terminated, then the time the operator first observed the eviction.
+func podEvictionTime(pod *corev1.Pod) time.Time {
+	for _, pc := range pod.Status.Conditions {
+		if pc.Type == corev1.DisruptionTarget && pc.Status == corev1.ConditionTrue && !pc.LastTransitionTime.IsZero() {
+			return pc.LastTransitionTime.Time
+		}
+	}
+	var finishedAt time.Time
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, cs := range statuses {
+			if cs.State.Terminated != nil && cs.State.Terminated.FinishedAt.After(finishedAt) {
+				finishedAt = cs.State.Terminated.FinishedAt.Time
+			}
+		}
+	}
+	if !finishedAt.IsZero() {
+		return finishedAt
+	}
+	if value, ok := pod.Annotations[evictedAtAnnotation]; ok {
+		t, err := time.Parse(time.RFC3339, value)
+		if err == nil {
+			return t
+		}
+		log.Printf("invalid value '%s' of annotation '%s' of pod '%s:%s'", value, evictedAtAnnotation, pod.Namespace, pod.Name)
+	}
+	return time.Time{}
+}
+
+// terminatedPodExpiry returns the time a Failed pod terminated by the node, e.g. on its graceful shutdown or to make room
+// for a pod of higher priority, has to be deleted at. ok is false if there is no duration for the reason of the pod.
+// Pods without a known termination time are deleted right away, as evicted pods are.
+func terminatedPodExpiry(pod *corev1.Pod, durations map[string]time.Duration) (expiry time.Time, ok bool) {
+	if pod.Status.Phase != corev1.PodFailed || isEvicted(pod) {
+		return time.Time{}, false
+	}
+	d := durations[pod.Status.Reason]
+	if d <= 0 {
+		return time.Time{}, false
+	}
+	terminatedAt := podEvictionTime(pod)
+	if terminatedAt.IsZero() {
+		return time.Time{}, true
+	}
+	return terminatedAt.Add(d), true
+}
+
+// terminationDuration returns the duration the pod is kept for after it was evicted or terminated by the node,
+// 0 if the pod was not or it is not deleted
+func (r retention) terminationDuration(pod *corev1.Pod) time.Duration {
+	if isEvicted(pod) {
+		return annotatedDuration(pod.Annotations, deleteEvictedAfterAnnotation, r.deleteEvictedAfter)
+	}
+	if pod.Status.Phase == corev1.PodFailed {
+		return r.deleteTerminatedAfter[pod.Status.Reason]
+	}
+	return 0
+}
+
+// recordEviction sets the evicted-at annotation of the pod to the current time, so the duration of evicted and terminated
+// pods is counted from the same moment across restarts of the operator. The pod is processed again once the
+// annotation shows up in the informer.
+func (c *Kleaner) recordEviction(pod *corev1.Pod) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{evictedAtAnnotation: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.kclient.CoreV1().Pods(pod.Namespace).Patch(c.ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if ignoreNotFound(err) != nil {
+		log.Printf("failed to record eviction of pod '%s:%s': %v", pod.Namespace, pod.Name, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// exclusions describe jobs and pods the Kleaner must never touch. Unlike the label selectors of the informers
+// they are evaluated on the cached objects, so they can be changed without a restart and match on owners as well.
+type exclusions struct {
+	// jobs and pods are nil if no selector is set
+	jobs labels.Selector
+	pods labels.Selector
+	// ownerKinds are kinds of owners, e.g. CronJob, whose jobs and pods are excluded
+	ownerKinds []string
+}
+
+func newExclusions(cfg Config) (exclusions, error) {
+	e := exclusions{ownerKinds: slices.Clone(cfg.ExcludeOwnedBy)}
+	if cfg.ExcludeJobSelector != "" {
+		selector, err := labels.Parse(cfg.ExcludeJobSelector)
+		if err != nil {
+			return exclusions{}, fmt.Errorf("invalid job exclusion selector: %v", err)
+		}
+		e.jobs = selector
+	}
+	if cfg.ExcludePodSelector != "" {
+		selector, err := labels.Parse(cfg.ExcludePodSelector)
+		if err != nil {
+			return exclusions{}, fmt.Errorf("invalid pod exclusion selector: %v", err)
+		}
+		e.pods = selector
+	}
+	return e, nil
+}
+
+// excludesJob returns true if the job matches the job selector or is owned by one of the excluded kinds
+func (e exclusions) excludesJob(job *batchv1.Job) bool {
+	if e.jobs != nil && e.jobs.Matches(labels.Set(job.Labels)) {
+		return true
+	}
+	return ownedByAny(getJobOwnerKinds(job), e.ownerKinds)
+}
+
+// excludesPod returns true if the pod matches the pod selector, is owned by one of the excluded kinds
+// or belongs to an excluded job. job is the owner of the pod, nil if it is not owned by a known Job.
+func (e exclusions) excludesPod(pod *corev1.Pod, job *batchv1.Job) bool {
+	if e.pods != nil && e.pods.Matches(labels.Set(pod.Labels)) {
+		return true
+	}
+	if ownedByAny(getPodOwnerKinds(pod), e.ownerKinds) {
+		return true
+	}
+	return job != nil && e.excludesJob(job)
+}
+
+func ownedByAny(ownerKinds, kinds []string) bool {
+	for _, kind := range ownerKinds {
+		if containsString(kinds, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// JobScope tells if jobs can be deleted by the Kleaner judging by the job alone. Namespace labels, policies and
+// annotations are not known, so jobs of namespaces selected by labels are always in the scope.
+type JobScope struct {
+	namespace string
+	// scope is nil unless namespaces are listed or excluded
+	scope *namespaceScope
+	// selector is nil if jobs are not selected by labels
+	selector             labels.Selector
+	exclusions           exclusions
+	ignoreOwnedByCronjob bool
+}
+
+// NewJobScope returns the scope of jobs configured by cfg
+func NewJobScope(cfg Config) (*JobScope, error) {
+	scope, err := newNamespaceScope(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil {
+		scope.selector = nil
+	}
+	e, err := newExclusions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &JobScope{namespace: cfg.Namespace, scope: scope, exclusions: e, ignoreOwnedByCronjob: cfg.IgnoreOwnedByCronjobs}
+	if selector := joinSelectors(cfg.LabelSelector, cfg.JobLabelSelector); selector != "" {
+		if s.selector, err = labels.Parse(selector); err != nil {
+			return nil, fmt.Errorf("invalid job label selector: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// Contains returns true if the job is watched and not excluded
+func (s *JobScope) Contains(job *batchv1.Job) bool {
+	if s.namespace != "" && job.Namespace != s.namespace {
+		return false
+	}
+	if !s.scope.contains(job.Namespace, nil) {
+		return false
+	}
+	if s.selector != nil && !s.selector.Matches(labels.Set(job.Labels)) {
+		return false
+	}
+	if s.ignoreOwnedByCronjob && isOwnedByCronJob(getJobOwnerKinds(job)) {
+		return false
+	}
+	return !s.exclusions.excludesJob(job)
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestKleaner_ProcessExclusions(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	finished := createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{})
+	finished.Namespace, finished.Name = "default", "finished"
+	finished.Labels = map[string]string{"ci": "true"}
+	excluded := createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{})
+	excluded.Namespace, excluded.Name = "default", "excluded"
+	excluded.Labels = map[string]string{"ci": "true", "keep": "true"}
+	ofCronJob := createJob(true, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{})
+	ofCronJob.Namespace, ofCronJob.Name = "default", "of-cronjob"
+	createPod := func(name, job string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "default",
+				Name:            name,
+				Labels:          labels,
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: job}},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodSucceeded,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(ts.Add(-time.Minute))},
+				},
+			},
+		}
+	}
+	kleaner := createTestKleaner(t, Config{
+		DryRun:                true,
+		JobLabelSelector:      "ci=true",
+		ExcludeJobSelector:    "keep=true",
+		ExcludePodSelector:    "debug=true",
+		ExcludeOwnedBy:        []string{"CronJob"},
+		DeleteSuccessfulAfter: metav1.Duration{Duration: 15 * time.Minute},
+	}, finished, excluded, ofCronJob)
+
+	testCases := map[string]struct {
+		obj      interface{}
+		expected time.Time
+	}{
+		"job is scheduled for its expiry": {
+			obj:      finished,
+			expected: ts.Add(14 * time.Minute),
+		},
+		"pod of job is scheduled for its expiry": {
+			obj:      createPod("finished-abcde", "finished", nil),
+			expected: ts.Add(14 * time.Minute),
+		},
+		"job matching exclusion selector is skipped": {
+			obj: excluded,
+		},
+		"pod of excluded job is skipped": {
+			obj: createPod("excluded-abcde", "excluded", nil),
+		},
+		"pod matching exclusion selector is skipped": {
+			obj: createPod("finished-debug", "finished", map[string]string{"debug": "true"}),
+		},
+		"job owned by excluded kind is skipped": {
+			obj: ofCronJob,
+		},
+		"pod of job out of the scope is skipped": {
+			obj: createPod("other-abcde", "other", nil),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, err := kleaner.Process(tc.obj)
+			if err != nil {
+				t.Fatalf("failed to process: %v", err)
+			}
+			if !result.Equal(tc.expected) {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
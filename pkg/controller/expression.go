@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// celCostLimit bounds the evaluation cost of a single expression, so a policy cannot stall the operator
+const celCostLimit = 1000000
+
+var (
+	celEnvOnce sync.Once
+	celEnv     *cel.Env
+	celEnvErr  error
+)
+
+// expression is a compiled CEL expression evaluated against a Job or a Pod available as `object`
+type expression struct {
+	source  string
+	program cel.Program
+}
+
+func newCELEnv() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		celEnv, celEnvErr = cel.NewEnv(cel.Variable("object", cel.DynType))
+	})
+	return celEnv, celEnvErr
+}
+
+// newExpression compiles the source, the result of the expression has to be a boolean
+func newExpression(source string) (*expression, error) {
+	env, err := newCELEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %v", source, issues.Err())
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression '%s' must return bool, got %s", source, ast.OutputType())
+	}
+	if field := trimmedField(ast.Expr()); field != "" {
+		return nil, fmt.Errorf("expression '%s' reads %s, which is dropped from cached objects", source, field)
+	}
+	program, err := env.Program(ast, cel.CostLimit(celCostLimit))
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %v", source, err)
+	}
+	return &expression{source: source, program: program}, nil
+}
+
+// matches evaluates the expression against the object. A nil expression matches everything,
+// an expression that failed to compile or to evaluate (e.g. because of a missing field) matches nothing.
+func (e *expression) matches(obj runtime.Object) bool {
+	if e == nil {
+		return true
+	}
+	if e.program == nil {
+		return false
+	}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return false
+	}
+	out, _, err := e.program.Eval(map[string]interface{}{"object": object})
+	if err != nil {
+		return false
+	}
+	result, ok := out.Value().(bool)
+	return ok && result
+}
+
+// cachedContainerFields are the fields kept of containers by transformObject
+var cachedContainerFields = map[string]interface{}{"name": nil, "image": nil}
+
+// cachedSpecFields are the fields kept of the specs of Pods and Jobs by transformObject, nil values are leaves
+var cachedSpecFields = map[string]interface{}{
+	"nodeName":                nil,
+	"containers":              cachedContainerFields,
+	"initContainers":          cachedContainerFields,
+	"parallelism":             nil,
+	"completions":             nil,
+	"activeDeadlineSeconds":   nil,
+	"backoffLimit":            nil,
+	"ttlSecondsAfterFinished": nil,
+	"suspend":                 nil,
+	"template": map[string]interface{}{
+		"spec": map[string]interface{}{"containers": cachedContainerFields, "initContainers": cachedContainerFields},
+	},
+}
+
+// trimmedField returns the first path of object.spec read by the expression which is dropped by transformObject,
+// empty if there is none. Fields read through variables of macros, e.g. `c` of `containers.all(c, ...)`, are not known.
+func trimmedField(e *exprpb.Expr) string {
+	var result string
+	var walk func(e *exprpb.Expr)
+	walk = func(e *exprpb.Expr) {
+		if e == nil || result != "" {
+			return
+		}
+		if path := objectPath(e); len(path) > 1 && path[0] == "spec" && !cachedPath(cachedSpecFields, path[1:]) {
+			result = "object." + strings.Join(path, ".")
+			return
+		}
+		switch k := e.ExprKind.(type) {
+		case *exprpb.Expr_SelectExpr:
+			walk(k.SelectExpr.Operand)
+		case *exprpb.Expr_CallExpr:
+			walk(k.CallExpr.Target)
+			for _, arg := range k.CallExpr.Args {
+				walk(arg)
+			}
+		case *exprpb.Expr_ListExpr:
+			for _, element := range k.ListExpr.Elements {
+				walk(element)
+			}
+		case *exprpb.Expr_StructExpr:
+			for _, entry := range k.StructExpr.Entries {
+				walk(entry.GetMapKey())
+				walk(entry.Value)
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			c := k.ComprehensionExpr
+			for _, child := range []*exprpb.Expr{c.IterRange, c.AccuInit, c.LoopCondition, c.LoopStep, c.Result} {
+				walk(child)
+			}
+		}
+	}
+	walk(e)
+	return result
+}
+
+// objectPath returns the fields selected from `object` by e, e.g. [spec containers image] for
+// `object.spec.containers[0].image`, nil if e is not a selection of `object`. Indexes of lists are skipped.
+func objectPath(e *exprpb.Expr) []string {
+	switch k := e.ExprKind.(type) {
+	case *exprpb.Expr_IdentExpr:
+		if k.IdentExpr.Name == "object" {
+			return []string{}
+		}
+	case *exprpb.Expr_SelectExpr:
+		if path := objectPath(k.SelectExpr.Operand); path != nil {
+			return append(path, k.SelectExpr.Field)
+		}
+	case *exprpb.Expr_CallExpr:
+		if k.CallExpr.Function != "_[_]" || len(k.CallExpr.Args) != 2 {
+			return nil
+		}
+		path := objectPath(k.CallExpr.Args[0])
+		if path == nil {
+			return nil
+		}
+		if key, ok := k.CallExpr.Args[1].ExprKind.(*exprpb.Expr_ConstExpr); ok {
+			if field, ok := key.ConstExpr.ConstantKind.(*exprpb.Constant_StringValue); ok {
+				return append(path, field.StringValue)
+			}
+		}
+		return path
+	}
+	return nil
+}
+
+func cachedPath(fields map[string]interface{}, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	child, ok := fields[path[0]]
+	if !ok {
+		return false
+	}
+	if child == nil {
+		return true
+	}
+	return cachedPath(child.(map[string]interface{}), path[1:])
+}
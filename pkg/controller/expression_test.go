@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNewExpression(t *testing.T) {
+	testCases := map[string]struct {
+		source string
+		err    bool
+	}{
+		"boolean expression": {
+			source: `object.metadata.namespace == "default"`,
+		},
+		"dynamic expression": {
+			source: `object.metadata.labels["keep"]`,
+		},
+		"syntax error": {
+			source: `object.metadata.namespace ==`,
+			err:    true,
+		},
+		"non boolean expression": {
+			source: `1 + 1`,
+			err:    true,
+		},
+		"unknown variable": {
+			source: `pod.status.phase == "Failed"`,
+			err:    true,
+		},
+		"cached spec fields": {
+			source: `object.spec.containers[0].image.startsWith("busybox") && object.spec.backoffLimit > 3 && has(object.spec.nodeName)`,
+		},
+		"cached job template fields": {
+			source: `object.spec.template.spec.containers.exists(c, c.name == "main")`,
+		},
+		"spec field dropped from the cache": {
+			source: `object.spec.template.spec.serviceAccountName == "ci"`,
+			err:    true,
+		},
+		"container field dropped from the cache": {
+			source: `has(object.spec.containers[0].command)`,
+			err:    true,
+		},
+		"spec field dropped from the cache selected by index": {
+			source: `object["spec"]["restartPolicy"] == "Never"`,
+			err:    true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := newExpression(tc.source)
+			if (err != nil) != tc.err {
+				t.Fatalf("failed, expected error %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestExpression_matches(t *testing.T) {
+	oomKilled := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137}}},
+			},
+		},
+	}
+	running := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			},
+		},
+	}
+	job := &batchv1.Job{Status: batchv1.JobStatus{Failed: 3}}
+	exitCode137 := `object.status.containerStatuses.exists(c, c.state.terminated.exitCode == 137)`
+	testCases := map[string]struct {
+		source   string
+		obj      runtime.Object
+		expected bool
+	}{
+		"matching pod": {
+			source:   exitCode137,
+			obj:      oomKilled,
+			expected: true,
+		},
+		"missing fields do not match": {
+			source:   exitCode137,
+			obj:      running,
+			expected: false,
+		},
+		"has macro": {
+			source:   `object.status.containerStatuses.exists(c, has(c.state.running))`,
+			obj:      running,
+			expected: true,
+		},
+		"matching job": {
+			source:   `object.status.failed >= 3`,
+			obj:      job,
+			expected: true,
+		},
+		"non matching job": {
+			source:   `object.status.failed > 3`,
+			obj:      job,
+			expected: false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			e, err := newExpression(tc.source)
+			if err != nil {
+				t.Fatalf("failed to compile: %v", err)
+			}
+			result := e.matches(tc.obj)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestExpression_matchesWithoutProgram(t *testing.T) {
+	var missing *expression
+	if !missing.matches(&corev1.Pod{}) {
+		t.Fatalf("failed, nil expression is expected to match everything")
+	}
+	invalid := &expression{source: "invalid =="}
+	if invalid.matches(&corev1.Pod{}) {
+		t.Fatalf("failed, invalid expression is expected to match nothing")
+	}
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"sync"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fairQueue is a workqueue.Interface handing out items of different namespaces in turns,
+// so a namespace with thousands of expired objects cannot starve the others.
+// Like the default queue it deduplicates items and never hands out an item that is being processed.
+type fairQueue struct {
+	cond *sync.Cond
+
+	// items waiting per namespace and the round-robin order of namespaces with waiting items
+	queues     map[string][]interface{}
+	namespaces []string
+
+	dirty      map[interface{}]struct{}
+	processing map[interface{}]struct{}
+
+	shuttingDown bool
+	drain        bool
+}
+
+var _ workqueue.Interface = &fairQueue{}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{
+		cond:       sync.NewCond(&sync.Mutex{}),
+		queues:     make(map[string][]interface{}),
+		dirty:      make(map[interface{}]struct{}),
+		processing: make(map[interface{}]struct{}),
+	}
+}
+
+func itemNamespace(item interface{}) string {
+	if key, ok := item.(queueKey); ok {
+		return key.namespace
+	}
+	return ""
+}
+
+// push adds the item to the end of its namespace queue, must be called with the lock held
+func (q *fairQueue) push(item interface{}) {
+	namespace := itemNamespace(item)
+	if len(q.queues[namespace]) == 0 {
+		q.namespaces = append(q.namespaces, namespace)
+	}
+	q.queues[namespace] = append(q.queues[namespace], item)
+	q.cond.Signal()
+}
+
+func (q *fairQueue) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+	q.dirty[item] = struct{}{}
+	if _, ok := q.processing[item]; ok {
+		// added back by Done
+		return
+	}
+	q.push(item)
+}
+
+func (q *fairQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	n := 0
+	for _, items := range q.queues {
+		n += len(items)
+	}
+	return n
+}
+
+// Get blocks until an item can be processed. Items are taken from the namespace at the head of the
+// round-robin order, which then moves to the end of it if it has more items.
+func (q *fairQueue) Get() (interface{}, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.namespaces) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.namespaces) == 0 {
+		return nil, true
+	}
+	namespace := q.namespaces[0]
+	q.namespaces = q.namespaces[1:]
+	items := q.queues[namespace]
+	item := items[0]
+	items[0] = nil
+	if len(items) == 1 {
+		delete(q.queues, namespace)
+	} else {
+		q.queues[namespace] = items[1:]
+		q.namespaces = append(q.namespaces, namespace)
+	}
+	q.processing[item] = struct{}{}
+	delete(q.dirty, item)
+	return item, false
+}
+
+func (q *fairQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, item)
+	if _, ok := q.dirty[item]; ok {
+		q.push(item)
+	} else if len(q.processing) == 0 {
+		// wakes up ShutDownWithDrain
+		q.cond.Broadcast()
+	}
+}
+
+func (q *fairQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.drain = false
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShutDownWithDrain shuts the queue down and waits until all items being processed are done
+func (q *fairQueue) ShutDownWithDrain() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.drain = true
+	q.shuttingDown = true
+	q.cond.Broadcast()
+	for len(q.processing) != 0 && q.drain {
+		q.cond.Wait()
+	}
+}
+
+func (q *fairQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFairQueue_Get(t *testing.T) {
+	q := newFairQueue()
+	for _, key := range []queueKey{
+		{kind: "Pod", namespace: "noisy", name: "a"},
+		{kind: "Pod", namespace: "noisy", name: "b"},
+		{kind: "Pod", namespace: "noisy", name: "c"},
+		{kind: "Pod", namespace: "quiet", name: "a"},
+		{kind: "Job", namespace: "other", name: "a"},
+		// duplicates are ignored
+		{kind: "Pod", namespace: "noisy", name: "a"},
+	} {
+		q.Add(key)
+	}
+	if q.Len() != 5 {
+		t.Fatalf("failed, expected 5 items, got %d", q.Len())
+	}
+	expected := []string{"noisy/a", "quiet/a", "other/a", "noisy/b", "noisy/c"}
+	var result []string
+	for q.Len() > 0 {
+		item, _ := q.Get()
+		result = append(result, item.(queueKey).storeKey())
+		q.Done(item)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("failed, expected %v, got %v", expected, result)
+	}
+}
+
+func TestFairQueue_Done(t *testing.T) {
+	q := newFairQueue()
+	key := queueKey{kind: "Pod", namespace: "default", name: "a"}
+	q.Add(key)
+	item, _ := q.Get()
+	// items added while being processed are handed out again only when they are done
+	q.Add(key)
+	if q.Len() != 0 {
+		t.Fatalf("failed, expected 0 items, got %d", q.Len())
+	}
+	q.Done(item)
+	if q.Len() != 1 {
+		t.Fatalf("failed, expected 1 item, got %d", q.Len())
+	}
+	q.ShutDown()
+	if item, shutdown := q.Get(); shutdown || item != key {
+		t.Fatalf("failed, expected %v, got %v (shutdown %v)", key, item, shutdown)
+	}
+	if _, shutdown := q.Get(); !shutdown {
+		t.Fatalf("failed, expected queue to be shut down")
+	}
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// jobHistoryIndex groups jobs of the job informer by owner or by the value of the group label
+const jobHistoryIndex = "jobHistory"
+
+// jobHistoryGroup returns the key of the group the job is counted in: the value of groupLabel if the job has it,
+// otherwise the controller owner of the job. Returns empty string for jobs that do not belong to any group.
+func jobHistoryGroup(job *batchv1.Job, groupLabel string) string {
+	if groupLabel != "" {
+		if value, ok := job.Labels[groupLabel]; ok {
+			return job.Namespace + "/label/" + value
+		}
+	}
+	for _, ow := range job.OwnerReferences {
+		if ow.Controller != nil && *ow.Controller {
+			return job.Namespace + "/" + ow.Kind + "/" + ow.Name
+		}
+	}
+	return ""
+}
+
+func jobHistoryIndexFunc(groupLabel string) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		job, ok := obj.(*batchv1.Job)
+		if !ok {
+			return nil, nil
+		}
+		if group := jobHistoryGroup(job, groupLabel); group != "" {
+			return []string{group}, nil
+		}
+		return nil, nil
+	}
+}
+
+// jobOutcome returns whether the job has finished and whether it failed, based on its conditions
+func jobOutcome(job *batchv1.Job) (finished, failed bool) {
+	for _, jc := range job.Status.Conditions {
+		if jc.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch jc.Type {
+		case batchv1.JobComplete:
+			return true, false
+		case batchv1.JobFailed:
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// isNewerJob returns true if a finished after b, ties are broken by creation time and name
+func isNewerJob(a, b *batchv1.Job) bool {
+	aFinished, bFinished := jobFinishTime(a), jobFinishTime(b)
+	if !aFinished.Equal(bFinished) {
+		return aFinished.After(bFinished)
+	}
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.After(b.CreationTimestamp.Time)
+	}
+	return a.Name > b.Name
+}
+
+// shouldDeleteJobByHistory decides about finished jobs when count based retention is enabled for their outcome,
+// i.e. keepLastSuccessful or keepLastFailed is greater than 0. The newest jobs of the group are kept and the rest
+// is deleted regardless of age. counted is false if the job has to be handled by the time based thresholds.
+func shouldDeleteJobByHistory(job *batchv1.Job, group []interface{}, keepLastSuccessful, keepLastFailed int) (counted, remove bool) {
+	finished, failed := jobOutcome(job)
+	if !finished {
+		return false, false
+	}
+	keep := keepLastSuccessful
+	if failed {
+		keep = keepLastFailed
+	}
+	if keep <= 0 {
+		return false, false
+	}
+	newer := 0
+	for _, obj := range group {
+		sibling, ok := obj.(*batchv1.Job)
+		if !ok || sibling.UID == job.UID || !sibling.DeletionTimestamp.IsZero() {
+			continue
+		}
+		siblingFinished, siblingFailed := jobOutcome(sibling)
+		if siblingFinished && siblingFailed == failed && isNewerJob(sibling, job) {
+			newer++
+		}
+	}
+	return true, newer >= keep
+}
+
+// jobHistoryDecision applies count based retention to the job, see shouldDeleteJobByHistory
+func (c *Kleaner) jobHistoryDecision(job *batchv1.Job, r retention) (counted, remove bool) {
+	if r.keepLastSuccessful <= 0 && r.keepLastFailed <= 0 {
+		return false, false
+	}
+	group := jobHistoryGroup(job, c.config.JobHistoryGroupLabel)
+	if group == "" {
+		return false, false
+	}
+	informers := c.informersOf(job.Namespace)
+	if informers == nil {
+		return false, false
+	}
+	siblings, err := informers.jobs.GetIndexer().ByIndex(jobHistoryIndex, group)
+	if err != nil {
+		log.Printf("failed to list jobs of '%s': %v", group, err)
+		return false, false
+	}
+	return shouldDeleteJobByHistory(job, siblings, r.keepLastSuccessful, r.keepLastFailed)
+}
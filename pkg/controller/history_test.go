@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func createFinishedJob(name string, failed bool, finished time.Time) *batchv1.Job {
+	condition := batchv1.JobComplete
+	if failed {
+		condition = batchv1.JobFailed
+	}
+	controller := true
+	ts := metav1.NewTime(finished)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name),
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "CronJob", Name: "backup", Controller: &controller},
+			},
+		},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: condition, Status: corev1.ConditionTrue, LastTransitionTime: ts},
+			},
+		},
+	}
+	if !failed {
+		job.Status.CompletionTime = &ts
+	}
+	return job
+}
+
+func TestJobHistoryGroup(t *testing.T) {
+	job := createFinishedJob("backup-1", false, time.Now())
+	testCases := map[string]struct {
+		labels     map[string]string
+		groupLabel string
+		owners     []metav1.OwnerReference
+		expected   string
+	}{
+		"grouped by owner": {
+			owners:   job.OwnerReferences,
+			expected: "default/CronJob/backup",
+		},
+		"grouped by label": {
+			labels:     map[string]string{"pipeline": "nightly"},
+			groupLabel: "pipeline",
+			owners:     job.OwnerReferences,
+			expected:   "default/label/nightly",
+		},
+		"owner is used when label is missing": {
+			groupLabel: "pipeline",
+			owners:     job.OwnerReferences,
+			expected:   "default/CronJob/backup",
+		},
+		"not controlled jobs are not grouped": {
+			owners:   []metav1.OwnerReference{{Kind: "CronJob", Name: "backup"}},
+			expected: "",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			j := job.DeepCopy()
+			j.Labels = tc.labels
+			j.OwnerReferences = tc.owners
+			result := jobHistoryGroup(j, tc.groupLabel)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestShouldDeleteJobByHistory(t *testing.T) {
+	ts := time.Now()
+	var group []interface{}
+	// successful jobs finished every minute, failed ones every hour, the newest ones have the lowest numbers
+	for i := 0; i < 5; i++ {
+		group = append(group,
+			createFinishedJob(fmt.Sprintf("successful-%d", i), false, ts.Add(-time.Duration(i)*time.Minute)),
+			createFinishedJob(fmt.Sprintf("failed-%d", i), true, ts.Add(-time.Duration(i)*time.Hour)),
+		)
+	}
+	running := createFinishedJob("running", false, ts)
+	running.Status.Conditions = nil
+	terminating := createFinishedJob("terminating", false, ts.Add(time.Minute))
+	terminating.DeletionTimestamp = &metav1.Time{Time: ts}
+	group = append(group, running, terminating)
+
+	testCases := map[string]struct {
+		job                *batchv1.Job
+		keepLastSuccessful int
+		keepLastFailed     int
+		counted            bool
+		remove             bool
+	}{
+		"newest successful job is kept": {
+			job:                group[0].(*batchv1.Job),
+			keepLastSuccessful: 2,
+			counted:            true,
+			remove:             false,
+		},
+		"old successful job is deleted": {
+			job:                group[4].(*batchv1.Job),
+			keepLastSuccessful: 2,
+			counted:            true,
+			remove:             true,
+		},
+		"failed jobs are counted separately": {
+			job:                group[3].(*batchv1.Job),
+			keepLastSuccessful: 1,
+			keepLastFailed:     2,
+			counted:            true,
+			remove:             false,
+		},
+		"old failed job is deleted": {
+			job:            group[5].(*batchv1.Job),
+			keepLastFailed: 2,
+			counted:        true,
+			remove:         true,
+		},
+		"time based retention for failed jobs": {
+			job:                group[5].(*batchv1.Job),
+			keepLastSuccessful: 2,
+			counted:            false,
+		},
+		"running jobs are not counted": {
+			job:                running,
+			keepLastSuccessful: 1,
+			counted:            false,
+		},
+		"terminating jobs do not take a slot": {
+			job:                group[0].(*batchv1.Job),
+			keepLastSuccessful: 1,
+			counted:            true,
+			remove:             false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			counted, remove := shouldDeleteJobByHistory(tc.job, group, tc.keepLastSuccessful, tc.keepLastFailed)
+			if counted != tc.counted || remove != tc.remove {
+				t.Fatalf("failed, expected %v/%v, got %v/%v", tc.counted, tc.remove, counted, remove)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// objectInformers are the pod and job informers of a single namespace, or of the whole scope
+// when namespaces are not sharded across replicas
+type objectInformers struct {
+	pods cache.SharedIndexInformer
+	jobs cache.SharedIndexInformer
+	// resources holds the informers of the custom resources by resourceKey
+	resources map[string]cache.SharedIndexInformer
+	stopCh    chan struct{}
+}
+
+func (i *objectInformers) run() {
+	go i.pods.Run(i.stopCh)
+	go i.jobs.Run(i.stopCh)
+	for _, informer := range i.resources {
+		go informer.Run(i.stopCh)
+	}
+}
+
+// informerOf returns the informer holding objects of the kind of the queue key, nil if the kind is unknown
+func (i *objectInformers) informerOf(kind string) cache.SharedIndexInformer {
+	switch kind {
+	case "Job":
+		return i.jobs
+	case "Pod":
+		return i.pods
+	}
+	return i.resources[kind]
+}
+
+func (i *objectInformers) hasSynced() bool {
+	return i.pods.HasSynced() && i.jobs.HasSynced()
+}
+
+// podFieldSelector returns the field selector of the pod informers. Running pods are never deleted,
+// so they can be filtered out by the apiserver. Set based selectors are not supported for fields,
+// so the remaining phases are watched by excluding Running rather than listing them.
+func podFieldSelector(cfg Config) string {
+	if !cfg.SkipRunningPods {
+		return ""
+	}
+	return fields.OneTermNotEqualSelector("status.phase", string(corev1.PodRunning)).String()
+}
+
+// joinSelectors combines label selectors, an object has to match all of them
+func joinSelectors(selectors ...string) string {
+	var result []string
+	for _, selector := range selectors {
+		if selector != "" {
+			result = append(result, selector)
+		}
+	}
+	return strings.Join(result, ",")
+}
+
+// newObjectInformers creates the informers of the namespace, empty - all namespaces
+func (c *Kleaner) newObjectInformers(namespace string) *objectInformers {
+	jobLabelSelector := joinSelectors(c.config.LabelSelector, c.config.JobLabelSelector)
+	podLabelSelector := joinSelectors(c.config.LabelSelector, c.config.PodLabelSelector)
+	podSelector := podFieldSelector(c.config)
+	jobInformer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = jobLabelSelector
+				return c.kclient.BatchV1().Jobs(namespace).List(c.ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = jobLabelSelector
+				return c.kclient.BatchV1().Jobs(namespace).Watch(c.ctx, options)
+			},
+		},
+		&batchv1.Job{},
+		resyncPeriod,
+		cache.Indexers{
+			cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			jobHistoryIndex:      jobHistoryIndexFunc(c.config.JobHistoryGroupLabel),
+		},
+	)
+	podIndexers := cache.Indexers{
+		cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+		podJobIndex:          podJobIndexFunc,
+	}
+	if c.nodeInformer != nil {
+		podIndexers[podNodeIndex] = podNodeIndexFunc
+	}
+	podInformer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = podLabelSelector
+				options.FieldSelector = podSelector
+				return c.kclient.CoreV1().Pods(namespace).List(c.ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = podLabelSelector
+				options.FieldSelector = podSelector
+				return c.kclient.CoreV1().Pods(namespace).Watch(c.ctx, options)
+			},
+		},
+		&corev1.Pod{},
+		resyncPeriod,
+		podIndexers,
+	)
+	// the informers may hold every pod and job of the cluster, only the fields the Kleaner reads are kept
+	if err := jobInformer.SetTransform(transformObject); err != nil {
+		log.Fatalf("failed to set transform of the job informer: %v", err)
+	}
+	if err := podInformer.SetTransform(transformObject); err != nil {
+		log.Fatalf("failed to set transform of the pod informer: %v", err)
+	}
+	jobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueJob,
+		UpdateFunc: func(old, new interface{}) {
+			if !reflect.DeepEqual(old, new) {
+				c.enqueueJob(new)
+			}
+		},
+		DeleteFunc: c.jobDeleted,
+	})
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			if !reflect.DeepEqual(old, new) {
+				c.enqueue(new)
+			}
+		},
+	})
+	informers := &objectInformers{pods: podInformer, jobs: jobInformer, stopCh: make(chan struct{})}
+	// custom resources do not depend on pods and jobs, objects of resources that are not installed are just never seen
+	for _, r := range c.config.Resources {
+		if informers.resources == nil {
+			informers.resources = make(map[string]cache.SharedIndexInformer)
+		}
+		informers.resources[resourceKey(r)] = c.newResourceInformer(r, namespace)
+	}
+	return informers
+}
+
+// informersOf returns the informers holding the objects of the namespace,
+// nil if the namespace is assigned to another replica
+func (c *Kleaner) informersOf(namespace string) *objectInformers {
+	c.informersMu.RLock()
+	defer c.informersMu.RUnlock()
+	if informers, ok := c.informers[metav1.NamespaceAll]; ok {
+		return informers
+	}
+	return c.informers[namespace]
+}
+
+func (c *Kleaner) listInformers() []*objectInformers {
+	c.informersMu.RLock()
+	defer c.informersMu.RUnlock()
+	result := make([]*objectInformers, 0, len(c.informers))
+	for _, informers := range c.informers {
+		result = append(result, informers)
+	}
+	return result
+}
+
+func (c *Kleaner) stopInformers() {
+	c.informersMu.Lock()
+	defer c.informersMu.Unlock()
+	for namespace, informers := range c.informers {
+		close(informers.stopCh)
+		delete(c.informers, namespace)
+	}
+}
+
+// namespacesInScope returns the namespaces whose objects are watched by the replica: the namespaces in the scope,
+// limited to the ones assigned to the replica when namespaces are sharded
+func (c *Kleaner) namespacesInScope() map[string]bool {
+	result := make(map[string]bool)
+	if c.scopeNamespaceInformer == nil {
+		// an explicit list of namespaces is watched without listing Namespace objects
+		for _, namespace := range c.scope.namespaces {
+			if c.scope.contains(namespace, nil) {
+				result[namespace] = true
+			}
+		}
+		return result
+	}
+	for _, obj := range c.scopeNamespaceInformer.GetStore().List() {
+		ns := obj.(*corev1.Namespace)
+		if c.scope.contains(ns.Name, ns.Labels) && (c.members == nil || c.members.OwnsNamespace(ns.Name, ns.Labels)) {
+			result[ns.Name] = true
+		}
+	}
+	return result
+}
+
+// syncNamespaces starts the informers of the namespaces entering the scope of the replica and stops the ones of the
+// namespaces leaving it, e.g. assigned to other replicas. Objects of new namespaces are enqueued by their informers.
+// It does nothing until the namespaces and members are synced, otherwise every replica would start informers of all
+// namespaces and rescan the namespaces for each of them listed initially. Run makes the first call.
+func (c *Kleaner) syncNamespaces() {
+	if !c.namespacesSynced.Load() {
+		return
+	}
+	c.informersMu.Lock()
+	defer c.informersMu.Unlock()
+	select {
+	case <-c.stopCh:
+		return
+	default:
+	}
+	wanted := c.namespacesInScope()
+	var added, removed []string
+	for namespace, informers := range c.informers {
+		if !wanted[namespace] {
+			close(informers.stopCh)
+			delete(c.informers, namespace)
+			removed = append(removed, namespace)
+		}
+	}
+	for namespace := range wanted {
+		if _, ok := c.informers[namespace]; !ok {
+			informers := c.newObjectInformers(namespace)
+			informers.run()
+			c.informers[namespace] = informers
+			added = append(added, namespace)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		sort.Strings(added)
+		sort.Strings(removed)
+		log.Printf("watching %d namespaces, added: %v, removed: %v", len(c.informers), added, removed)
+	}
+}
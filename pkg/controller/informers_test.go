@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestPodFieldSelector(t *testing.T) {
+	testCases := map[string]struct {
+		config   Config
+		phase    corev1.PodPhase
+		expected bool
+	}{
+		"running pods are watched by default": {
+			config:   Config{},
+			phase:    corev1.PodRunning,
+			expected: true,
+		},
+		"running pods are skipped": {
+			config:   Config{SkipRunningPods: true},
+			phase:    corev1.PodRunning,
+			expected: false,
+		},
+		"pending pods are watched": {
+			config:   Config{SkipRunningPods: true},
+			phase:    corev1.PodPending,
+			expected: true,
+		},
+		"pods in unknown phase are watched": {
+			config:   Config{SkipRunningPods: true},
+			phase:    corev1.PodUnknown,
+			expected: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			selector, err := fields.ParseSelector(podFieldSelector(tc.config))
+			if err != nil {
+				t.Fatalf("failed to parse selector: %v", err)
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod"}, Status: corev1.PodStatus{Phase: tc.phase}}
+			result := selector.Matches(fields.Set{"status.phase": string(pod.Status.Phase)})
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestNamespaceScope(t *testing.T) {
+	testCases := map[string]struct {
+		cfg      Config
+		name     string
+		labels   map[string]string
+		expected bool
+	}{
+		"every namespace is in the scope by default": {
+			name:     "kube-system",
+			expected: true,
+		},
+		"listed namespace is in the scope": {
+			cfg:      Config{Namespaces: []string{"ci", "data"}},
+			name:     "data",
+			expected: true,
+		},
+		"namespace missing from the list is out of the scope": {
+			cfg:  Config{Namespaces: []string{"ci", "data"}},
+			name: "web",
+		},
+		"excluded namespace is out of the scope": {
+			cfg:  Config{Namespaces: []string{"ci", "kube-system"}, ExcludeNamespaces: []string{"kube-*"}},
+			name: "kube-system",
+		},
+		"namespace with matching labels is in the scope": {
+			cfg:      Config{NamespaceSelector: "cleanup=enabled"},
+			name:     "tenant-a",
+			labels:   map[string]string{"cleanup": "enabled"},
+			expected: true,
+		},
+		"namespace without matching labels is out of the scope": {
+			cfg:  Config{NamespaceSelector: "cleanup=enabled"},
+			name: "tenant-b",
+		},
+		"excluded namespace with matching labels is out of the scope": {
+			cfg:    Config{NamespaceSelector: "cleanup=enabled", ExcludeNamespaces: []string{"kube-system"}},
+			name:   "kube-system",
+			labels: map[string]string{"cleanup": "enabled"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			scope, err := newNamespaceScope(tc.cfg)
+			if err != nil {
+				t.Fatalf("failed to create scope: %v", err)
+			}
+			result := scope.contains(tc.name, tc.labels)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestKleaner_syncNamespaces(t *testing.T) {
+	// clients do not connect until informers are started
+	kclient := kubernetes.NewForConfigOrDie(&rest.Config{Host: "http://127.0.0.1:1"})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	kleaner := NewKleaner(context.Background(), kclient, nil, Config{Namespaces: []string{"ci", "dev"}}, nil, stopCh)
+	defer kleaner.stopInformers()
+	kleaner.syncNamespaces()
+	if informers := kleaner.listInformers(); len(informers) != 0 {
+		t.Fatalf("failed, expected no informers before the namespaces are synced, got %d", len(informers))
+	}
+	kleaner.namespacesSynced.Store(true)
+	kleaner.syncNamespaces()
+	if kleaner.informersOf("ci") == nil || kleaner.informersOf("dev") == nil || kleaner.informersOf("prod") != nil {
+		t.Fatalf("failed, expected informers of ci and dev, got %d informers", len(kleaner.listInformers()))
+	}
+}
@@ -7,33 +7,65 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+// isExpired returns true if ok is set and the expiry time has passed
+func isExpired(expiry time.Time, ok bool) bool {
+	return ok && !time.Now().Before(expiry)
+}
+
 func shouldDeleteJob(job *batchv1.Job, deleteSuccessfulAfter, deleteFailedAfter time.Duration, ignoreCronJobs bool) bool {
+	return isExpired(jobExpiry(job, deleteSuccessfulAfter, deleteFailedAfter, ignoreCronJobs))
+}
+
+// jobExpiry returns the time the job has to be deleted at, ok is false if it must not be deleted in its current state
+func jobExpiry(job *batchv1.Job, deleteSuccessfulAfter, deleteFailedAfter time.Duration, ignoreCronJobs bool) (expiry time.Time, ok bool) {
 	if ignoreCronJobs {
 		owners := getJobOwnerKinds(job)
 		if isOwnedByCronJob(owners) {
-			return false
+			return time.Time{}, false
 		}
 	}
 
+	// per-job annotations take precedence over the configured durations
+	deleteSuccessfulAfter = annotatedDuration(job.Annotations, deleteSuccessfulAfterAnnotation, deleteSuccessfulAfter)
+	deleteFailedAfter = annotatedDuration(job.Annotations, deleteFailedAfterAnnotation, deleteFailedAfter)
+
 	finishTime := jobFinishTime(job)
 
 	if finishTime.IsZero() {
-		return false
+		return time.Time{}, false
 	}
 
-	timeSinceFinish := time.Since(finishTime)
-
-	if job.Status.Succeeded > 0 {
-		if deleteSuccessfulAfter > 0 && timeSinceFinish > deleteSuccessfulAfter {
-			return true
-		}
+	if job.Status.Succeeded > 0 && deleteSuccessfulAfter > 0 {
+		expiry, ok = finishTime.Add(deleteSuccessfulAfter), true
 	}
-	if isFailed(job) {
-		if deleteFailedAfter > 0 && timeSinceFinish >= deleteFailedAfter {
-			return true
+	if isFailed(job) && deleteFailedAfter > 0 {
+		if failedExpiry := finishTime.Add(deleteFailedAfter); !ok || failedExpiry.Before(expiry) {
+			expiry, ok = failedExpiry, true
 		}
 	}
-	return false
+	return expiry, ok
+}
+
+// jobTTL returns spec.ttlSecondsAfterFinished of the job, ok is false if it is not set
+func jobTTL(job *batchv1.Job) (ttl time.Duration, ok bool) {
+	if job.Spec.TTLSecondsAfterFinished == nil {
+		return 0, false
+	}
+	return time.Duration(*job.Spec.TTLSecondsAfterFinished) * time.Second, true
+}
+
+// shouldDeleteJobByTTL returns true if the job finished at least ttl ago, unlike the delete-* durations 0 means immediately
+func shouldDeleteJobByTTL(job *batchv1.Job, ttl time.Duration) bool {
+	return isExpired(jobTTLExpiry(job, ttl))
+}
+
+// jobTTLExpiry returns the time the ttl of the job expires at, ok is false if the job has not finished yet
+func jobTTLExpiry(job *batchv1.Job, ttl time.Duration) (time.Time, bool) {
+	finishTime := jobFinishTime(job)
+	if finishTime.IsZero() {
+		return time.Time{}, false
+	}
+	return finishTime.Add(ttl), true
 }
 
 func getJobOwnerKinds(job *batchv1.Job) []string {
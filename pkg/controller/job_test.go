@@ -1,12 +1,16 @@
 package controller
 
 import (
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
 )
 
 func createJob(ownedByCronJob bool, completed time.Time, active, succeeded, failed int32, conditions []batchv1.JobCondition) *batchv1.Job {
@@ -31,6 +35,11 @@ func createJob(ownedByCronJob bool, completed time.Time, active, succeeded, fail
 	return &job
 }
 
+func withJobAnnotations(job *batchv1.Job, annotations map[string]string) *batchv1.Job {
+	job.Annotations = annotations
+	return job
+}
+
 func TestKleaner_DeleteJob(t *testing.T) {
 	ts := time.Now()
 	testCases := map[string]struct {
@@ -135,6 +144,30 @@ func TestKleaner_DeleteJob(t *testing.T) {
 			ignoreCron: false,
 			expected:   true,
 		},
+		"annotation extends retention of successful jobs": {
+			jobSpec: withJobAnnotations(createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{}),
+				map[string]string{deleteSuccessfulAfterAnnotation: "2h"}),
+			successful: time.Second,
+			failed:     time.Second,
+			ignoreCron: false,
+			expected:   false,
+		},
+		"annotation enables deletion of failed jobs": {
+			jobSpec: withJobAnnotations(createJob(false, ts.Add(-time.Minute), 0, 0, 1, []batchv1.JobCondition{}),
+				map[string]string{deleteFailedAfterAnnotation: "30s"}),
+			successful: time.Second,
+			failed:     0,
+			ignoreCron: false,
+			expected:   true,
+		},
+		"invalid annotation is ignored": {
+			jobSpec: withJobAnnotations(createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{}),
+				map[string]string{deleteSuccessfulAfterAnnotation: "two hours"}),
+			successful: time.Second,
+			failed:     time.Second,
+			ignoreCron: false,
+			expected:   true,
+		},
 	}
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
@@ -145,3 +178,87 @@ func TestKleaner_DeleteJob(t *testing.T) {
 		})
 	}
 }
+
+func withTTL(job *batchv1.Job, seconds int32) *batchv1.Job {
+	job.Spec.TTLSecondsAfterFinished = &seconds
+	return job
+}
+
+func TestShouldDeleteJobByTTL(t *testing.T) {
+	ts := time.Now()
+	testCases := map[string]struct {
+		jobSpec  *batchv1.Job
+		expected bool
+	}{
+		"expired ttl": {
+			jobSpec:  withTTL(createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{}), 30),
+			expected: true,
+		},
+		"non-expired ttl": {
+			jobSpec:  withTTL(createJob(false, ts.Add(-time.Minute), 0, 0, 1, []batchv1.JobCondition{}), 120),
+			expected: false,
+		},
+		"zero ttl deletes immediately": {
+			jobSpec:  withTTL(createJob(false, ts, 0, 1, 0, []batchv1.JobCondition{}), 0),
+			expected: true,
+		},
+		"unfinished job": {
+			jobSpec:  withTTL(createJob(false, time.Time{}, 1, 0, 0, []batchv1.JobCondition{}), 0),
+			expected: false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ttl, _ := jobTTL(tc.jobSpec)
+			result := shouldDeleteJobByTTL(tc.jobSpec, ttl)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestKleaner_jobDeleted(t *testing.T) {
+	ts := time.Now()
+	testCases := map[string]struct {
+		jobSpec          *batchv1.Job
+		deletedByKleaner bool
+		expected         uint64
+	}{
+		"deleted by ttl controller": {
+			jobSpec:  withTTL(createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{}), 30),
+			expected: 1,
+		},
+		"deleted by kleaner": {
+			jobSpec:          withTTL(createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{}), 30),
+			deletedByKleaner: true,
+			expected:         0,
+		},
+		"deleted before ttl expired": {
+			jobSpec:  withTTL(createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{}), 120),
+			expected: 0,
+		},
+		"job without ttl": {
+			jobSpec:  createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{}),
+			expected: 0,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tc.jobSpec.Namespace = "ttl-" + strings.ReplaceAll(name, " ", "-")
+			tc.jobSpec.UID = types.UID(name)
+			kleaner := &Kleaner{}
+			if tc.deletedByKleaner {
+				kleaner.deletedJobs.Store(tc.jobSpec.UID, struct{}{})
+			}
+			kleaner.jobDeleted(cache.DeletedFinalStateUnknown{Obj: tc.jobSpec})
+			result := metrics.GetOrCreateCounter(metricName(jobDeletedByTTLMetric, tc.jobSpec.Namespace)).Get()
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+			if _, ok := kleaner.deletedJobs.Load(tc.jobSpec.UID); ok {
+				t.Fatalf("failed, deleted job is expected to be forgotten")
+			}
+		})
+	}
+}
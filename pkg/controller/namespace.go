@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newNamespaceInformer creates informer for Namespace objects,
+// if namespace is not empty only this namespace is watched
+func newNamespaceInformer(ctx context.Context, kclient *kubernetes.Clientset, namespace string) cache.SharedIndexInformer {
+	fieldSelector := ""
+	if namespace != metav1.NamespaceAll {
+		fieldSelector = fields.OneTermEqualSelector(metav1.ObjectNameField, namespace).String()
+	}
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = fieldSelector
+				return kclient.CoreV1().Namespaces().List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = fieldSelector
+				return kclient.CoreV1().Namespaces().Watch(ctx, options)
+			},
+		},
+		&corev1.Namespace{},
+		resyncPeriod,
+		cache.Indexers{},
+	)
+}
+
+// namespaceScope limits the namespaces watched by the Kleaner to an explicit list, namespaces matching
+// a label selector or both, minus excluded namespaces
+type namespaceScope struct {
+	namespaces []string
+	exclude    []string
+	// selector is nil when namespaces are not selected by labels
+	selector labels.Selector
+}
+
+// newNamespaceScope returns the scope configured by cfg, nil if the scope is a single namespace or the whole cluster
+func newNamespaceScope(cfg Config) (*namespaceScope, error) {
+	if len(cfg.Namespaces) == 0 && len(cfg.ExcludeNamespaces) == 0 && cfg.NamespaceSelector == "" {
+		return nil, nil
+	}
+	if cfg.Namespace != metav1.NamespaceAll {
+		return nil, fmt.Errorf("namespace can not be combined with namespaces, excludeNamespaces or namespaceSelector")
+	}
+	for _, pattern := range cfg.ExcludeNamespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid excluded namespace pattern '%s': %v", pattern, err)
+		}
+	}
+	scope := &namespaceScope{namespaces: slices.Clone(cfg.Namespaces), exclude: slices.Clone(cfg.ExcludeNamespaces)}
+	if cfg.NamespaceSelector != "" {
+		selector, err := labels.Parse(cfg.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %v", err)
+		}
+		scope.selector = selector
+	}
+	return scope, nil
+}
+
+// excludeOnly returns true if namespaces are only excluded, objects of such a scope are watched cluster-wide
+// and the ones of excluded namespaces are skipped, so it does not take an informer per namespace
+func (s *namespaceScope) excludeOnly() bool {
+	return s != nil && len(s.namespaces) == 0 && s.selector == nil
+}
+
+// static returns true if the namespaces of the scope are known without watching Namespace objects
+func (s *namespaceScope) static() bool {
+	return s != nil && len(s.namespaces) > 0 && s.selector == nil
+}
+
+// contains returns true if the namespace is in the scope, a nil scope contains every namespace
+func (s *namespaceScope) contains(name string, nsLabels map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	for _, pattern := range s.exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(s.namespaces) > 0 && !containsString(s.namespaces, name) {
+		return false
+	}
+	return s.selector == nil || s.selector.Matches(labels.Set(nsLabels))
+}
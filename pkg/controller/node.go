@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// podNodeIndex indexes pods of the pod informer by the name of the node they are bound to
+	podNodeIndex = "node"
+
+	lostNodePodDeletedMetric       = "lost_node_pods_deleted_total"
+	lostNodePodDeletedFailedMetric = "lost_node_pods_deleted_failed_total"
+)
+
+func podNodeIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// newNodeInformer creates informer for Node objects
+func newNodeInformer(ctx context.Context, kclient *kubernetes.Clientset) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return kclient.CoreV1().Nodes().List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return kclient.CoreV1().Nodes().Watch(ctx, options)
+			},
+		},
+		&corev1.Node{},
+		resyncPeriod,
+		cache.Indexers{},
+	)
+}
+
+// nodeReady returns the status of the Ready condition of the node and the time it was last changed
+func nodeReady(node *corev1.Node) (status corev1.ConditionStatus, since time.Time) {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status, condition.LastTransitionTime.Time
+		}
+	}
+	return corev1.ConditionUnknown, time.Time{}
+}
+
+// nodeLostSince returns the time the node has been NotReady or deleted since, zero if it is Ready
+func (c *Kleaner) nodeLostSince(name string) time.Time {
+	obj, exists, err := c.nodeInformer.GetStore().GetByKey(name)
+	if err == nil && exists {
+		if status, since := nodeReady(obj.(*corev1.Node)); status != corev1.ConditionTrue {
+			return since
+		}
+		return time.Time{}
+	}
+	// nodes deleted before the Kleaner was started are counted as deleted when first missed
+	since, _ := c.deletedNodes.LoadOrStore(name, time.Now())
+	return since.(time.Time)
+}
+
+// nodeChanged enqueues the pods of the node when it becomes Ready or NotReady
+func (c *Kleaner) nodeChanged(old, new interface{}) {
+	oldStatus, _ := nodeReady(old.(*corev1.Node))
+	newStatus, _ := nodeReady(new.(*corev1.Node))
+	if oldStatus != newStatus {
+		c.enqueueNode(new.(*corev1.Node).Name)
+	}
+}
+
+func (c *Kleaner) nodeDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	c.deletedNodes.Store(node.Name, time.Now())
+	c.enqueueNode(node.Name)
+}
+
+// enqueueNode enqueues all pods bound to the node
+func (c *Kleaner) enqueueNode(name string) {
+	for _, informers := range c.listInformers() {
+		c.enqueueIndexed(informers.pods, podNodeIndex, name)
+	}
+}
+
+// lostNodePodExpiry returns the time a pod bound to a lost node has to be force deleted at: the node has been
+// NotReady or deleted, or the pod has been in the Unknown phase, for longer than after. The kubelet of a lost node
+// never confirms the termination of its pods, so they can only be force deleted. ok is false if the pod must not
+// be force deleted, e.g. it has finalizers that are not allowed to be removed.
+func (c *Kleaner) lostNodePodExpiry(pod *corev1.Pod, after time.Duration, removableFinalizers []string) (expiry time.Time, ok bool) {
+	if after <= 0 || pod.Spec.NodeName == "" || !finalizersRemovable(pod, removableFinalizers) {
+		return time.Time{}, false
+	}
+	since := c.nodeLostSince(pod.Spec.NodeName)
+	if since.IsZero() && pod.Status.Phase == corev1.PodUnknown {
+		since = podFinishTime(pod)
+	}
+	if since.IsZero() {
+		return time.Time{}, false
+	}
+	return since.Add(after), true
+}
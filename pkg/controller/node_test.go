@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func createNode(name string, ready corev1.ConditionStatus, since time.Time) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: ready, LastTransitionTime: metav1.NewTime(since)},
+			},
+		},
+	}
+}
+
+func TestKleaner_lostNodePodExpiry(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	nodeInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &corev1.Node{}, 0, cache.Indexers{})
+	for _, node := range []*corev1.Node{
+		createNode("ready", corev1.ConditionTrue, ts.Add(-time.Hour)),
+		createNode("not-ready", corev1.ConditionFalse, ts.Add(-time.Hour)),
+		createNode("unreachable", corev1.ConditionUnknown, ts.Add(-time.Minute)),
+	} {
+		if err := nodeInformer.GetStore().Add(node); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+	}
+	kleaner := &Kleaner{nodeInformer: nodeInformer}
+	kleaner.deletedNodes.Store("deleted", ts.Add(-2*time.Hour))
+
+	createPod := func(node string, phase corev1.PodPhase, finalizers ...string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", Finalizers: finalizers},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{
+				Phase: phase,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(ts.Add(-3 * time.Hour))},
+				},
+			},
+		}
+	}
+	testCases := map[string]struct {
+		pod        *corev1.Pod
+		expiry     time.Time
+		expectedOk bool
+	}{
+		"pod of ready node is kept": {
+			pod: createPod("ready", corev1.PodRunning),
+		},
+		"pod of not ready node is force deleted": {
+			pod:        createPod("not-ready", corev1.PodRunning),
+			expiry:     ts.Add(-time.Hour).Add(15 * time.Minute),
+			expectedOk: true,
+		},
+		"pod of unreachable node is force deleted": {
+			pod:        createPod("unreachable", corev1.PodRunning),
+			expiry:     ts.Add(-time.Minute).Add(15 * time.Minute),
+			expectedOk: true,
+		},
+		"pod of deleted node is force deleted": {
+			pod:        createPod("deleted", corev1.PodRunning),
+			expiry:     ts.Add(-2 * time.Hour).Add(15 * time.Minute),
+			expectedOk: true,
+		},
+		"pod in unknown phase is force deleted": {
+			pod:        createPod("ready", corev1.PodUnknown),
+			expiry:     ts.Add(-3 * time.Hour).Add(15 * time.Minute),
+			expectedOk: true,
+		},
+		"pod with other finalizers is not force deleted": {
+			pod: createPod("not-ready", corev1.PodRunning, "example.com/backup"),
+		},
+		"unscheduled pod is kept": {
+			pod: createPod("", corev1.PodPending),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			expiry, ok := kleaner.lostNodePodExpiry(tc.pod, 15*time.Minute, nil)
+			if ok != tc.expectedOk || !expiry.Equal(tc.expiry) {
+				t.Fatalf("failed, expected %v %v, got %v %v", tc.expiry, tc.expectedOk, expiry, ok)
+			}
+		})
+	}
+}
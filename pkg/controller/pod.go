@@ -9,67 +9,168 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
-func podRelatedToCronJob(pod *corev1.Pod, jobStore cache.Store) bool {
+// getPodOwnerJob returns the Job owning the pod or nil if the pod is not owned by a Job
+// or the Job is not in the store
+func getPodOwnerJob(pod *corev1.Pod, jobStore cache.Store) *batchv1.Job {
 	isOwnedByJob := isOwnedByJob(getPodOwnerKinds(pod))
 	if !isOwnedByJob {
-		return false
+		return nil
 	}
 	jobOwnerName := pod.OwnerReferences[0].Name
 	jobOwner, exists, err := jobStore.GetByKey(pod.Namespace + "/" + jobOwnerName)
 	if err != nil {
 		log.Printf("Can't find job '%s:%s`", pod.Namespace, jobOwnerName)
-	} else if exists && isOwnedByCronJob(getJobOwnerKinds(jobOwner.(*batchv1.Job))) {
-		return true
+		return nil
 	}
-	return false
+	if !exists {
+		return nil
+	}
+	return jobOwner.(*batchv1.Job)
+}
+
+func podRelatedToCronJob(pod *corev1.Pod, jobStore cache.Store) bool {
+	job := getPodOwnerJob(pod, jobStore)
+	return job != nil && isOwnedByCronJob(getJobOwnerKinds(job))
 }
 
 func shouldDeletePod(pod *corev1.Pod, orphaned, pending, evicted, successful, failed time.Duration) bool {
+	return isExpired(podExpiry(pod, orphaned, pending, evicted, successful, failed))
+}
+
+// podExpiry returns the time the pod has to be deleted at, ok is false if it must not be deleted in its current state
+func podExpiry(pod *corev1.Pod, orphaned, pending, evicted, successful, failed time.Duration) (expiry time.Time, ok bool) {
+	// per-pod annotations take precedence over the configured durations
+	orphaned = annotatedDuration(pod.Annotations, deleteOrphanedAfterAnnotation, orphaned)
+	pending = annotatedDuration(pod.Annotations, deletePendingAfterAnnotation, pending)
+	evicted = annotatedDuration(pod.Annotations, deleteEvictedAfterAnnotation, evicted)
+	successful = annotatedDuration(pod.Annotations, deleteSuccessfulAfterAnnotation, successful)
+	failed = annotatedDuration(pod.Annotations, deleteFailedAfterAnnotation, failed)
+
 	// evicted pods, those with or without owner references, but in Evicted state
-	//  - uses c.deleteEvictedAfter, this one is tricky, because there is no timestamp of eviction.
-	// So, basically it will be removed as soon as discovered
-	if pod.Status.Phase == corev1.PodFailed && pod.Status.Reason == "Evicted" && evicted > 0 {
-		return true
+	//  - uses c.deleteEvictedAfter counted from the eviction, pods without a known eviction time
+	// are removed as soon as discovered
+	if isEvicted(pod) && evicted > 0 {
+		evictedAt := podEvictionTime(pod)
+		if evictedAt.IsZero() {
+			return time.Time{}, true
+		}
+		return evictedAt.Add(evicted), true
 	}
 	owners := getPodOwnerKinds(pod)
 	podFinishTime := podFinishTime(pod)
 	if !podFinishTime.IsZero() {
-		age := time.Since(podFinishTime)
 		// orphaned pod: those that do not have any owner references
 		// - uses c.deleteOrphanedAfter
-		if len(owners) == 0 {
-			if orphaned > 0 && age >= orphaned {
-				return true
-			}
+		if len(owners) == 0 && orphaned > 0 {
+			expiry, ok = podFinishTime.Add(orphaned), true
 		}
 		// owned by job, have exactly one ownerReference present and its kind is Job
 		//  - uses the c.deleteSuccessfulAfter, c.deleteFailedAfter, c.deletePendingAfter
 		if isOwnedByJob(owners) {
 			switch pod.Status.Phase {
 			case corev1.PodSucceeded:
-				if successful > 0 && age >= successful {
-					return true
+				if successful > 0 {
+					return podFinishTime.Add(successful), true
 				}
 			case corev1.PodFailed:
-				if failed > 0 && age >= failed {
-					return true
+				if failed > 0 {
+					return podFinishTime.Add(failed), true
 				}
-			default:
-				return false
 			}
-			return false
+			return time.Time{}, false
 		}
 	}
 	if pod.Status.Phase == corev1.PodPending && pending > 0 {
 		t := podLastTransitionTime(pod)
 		if t.IsZero() {
-			return false
+			return expiry, ok
 		}
-		if time.Now().Sub(t) >= pending {
-			return true
+		if pendingExpiry := t.Add(pending); !ok || pendingExpiry.Before(expiry) {
+			expiry, ok = pendingExpiry, true
 		}
 	}
-	return false
+	return expiry, ok
+}
+
+// States of Pending pods, the reason label of pendingPodDeletedMetric
+const (
+	pendingUnschedulable = "unschedulable"
+	pendingInitializing  = "initializing"
+	pendingCreating      = "creating"
+)
+
+// pendingState returns the state of a Pending pod and the time it has been in the state since: not scheduled yet,
+// scheduled and running its init containers, or initialized and creating its containers, e.g. waiting for a volume.
+// state is empty if the pod is not Pending or its state is not known yet.
+func pendingState(pod *corev1.Pod) (state string, since time.Time) {
+	if pod.Status.Phase != corev1.PodPending {
+		return "", time.Time{}
+	}
+	var initialized *corev1.PodCondition
+	for i, pc := range pod.Status.Conditions {
+		switch {
+		case pc.Type == corev1.PodScheduled && pc.Status == corev1.ConditionFalse:
+			return pendingUnschedulable, pc.LastTransitionTime.Time
+		case pc.Type == corev1.PodInitialized:
+			initialized = &pod.Status.Conditions[i]
+		}
+	}
+	if initialized == nil {
+		return "", time.Time{}
+	}
+	if initialized.Status == corev1.ConditionTrue {
+		return pendingCreating, initialized.LastTransitionTime.Time
+	}
+	return pendingInitializing, initialized.LastTransitionTime.Time
+}
+
+// pendingPodExpiry returns the time a scheduled Pending pod has to be deleted at, ok is false if it is not stuck
+// creating its containers or running its init containers, or the duration of its state is not set.
+// Unschedulable pods are handled by podExpiry.
+func pendingPodExpiry(pod *corev1.Pod, creating, initializing time.Duration) (expiry time.Time, ok bool) {
+	var d time.Duration
+	state, since := pendingState(pod)
+	switch state {
+	case pendingCreating:
+		d = annotatedDuration(pod.Annotations, deleteCreatingAfterAnnotation, creating)
+	case pendingInitializing:
+		d = annotatedDuration(pod.Annotations, deleteInitializingAfterAnnotation, initializing)
+	}
+	if d <= 0 || since.IsZero() {
+		return time.Time{}, false
+	}
+	return since.Add(d), true
+}
+
+// waitingPodExpiry returns the time the pod has to be deleted at if one of its containers is waiting for a reason
+// with a duration, e.g. CrashLoopBackOff. The duration is counted from the time the pod stopped being ready, or was
+// created if it never was. Only pods owned by Jobs and orphaned pods are deleted, unless anyOwner is set.
+func waitingPodExpiry(pod *corev1.Pod, durations map[string]time.Duration, anyOwner bool) (expiry time.Time, ok bool) {
+	if len(durations) == 0 {
+		return time.Time{}, false
+	}
+	if owners := getPodOwnerKinds(pod); !anyOwner && len(owners) > 0 && !isOwnedByJob(owners) {
+		return time.Time{}, false
+	}
+	since := podFinishTime(pod)
+	if since.IsZero() {
+		since = pod.CreationTimestamp.Time
+	}
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.State.Waiting == nil {
+				continue
+			}
+			d, found := durations[status.State.Waiting.Reason]
+			if !found || d <= 0 {
+				continue
+			}
+			if waitingExpiry := since.Add(d); !ok || waitingExpiry.Before(expiry) {
+				expiry, ok = waitingExpiry, true
+			}
+		}
+	}
+	return expiry, ok
 }
 
 func getPodOwnerKinds(pod *corev1.Pod) []string {
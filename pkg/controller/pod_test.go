@@ -132,6 +132,27 @@ func TestKleaner_DeletePod(t *testing.T) {
 			failed:     0,
 			expected:   true,
 		},
+		"evicted pods should be kept for the duration since the eviction": {
+			podSpec: &corev1.Pod{
+				Status: corev1.PodStatus{
+					Phase:  corev1.PodFailed,
+					Reason: "Evicted",
+					Conditions: []corev1.PodCondition{
+						{
+							Type:               corev1.DisruptionTarget,
+							Status:             corev1.ConditionTrue,
+							LastTransitionTime: metav1.NewTime(ts.Add(-time.Minute * 2)),
+						},
+					},
+				},
+			},
+			orphaned:   0,
+			pending:    0,
+			evicted:    time.Hour,
+			successful: 0,
+			failed:     0,
+			expected:   false,
+		},
 		"expired pending pods should be deleted": {
 			podSpec: &corev1.Pod{
 				Status: corev1.PodStatus{
@@ -152,6 +173,60 @@ func TestKleaner_DeletePod(t *testing.T) {
 			failed:     0,
 			expected:   true,
 		},
+		"annotation extends retention of failed pods owned by Job": {
+			podSpec: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						deleteFailedAfterAnnotation: "168h",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "Job",
+						},
+					},
+				},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodFailed,
+					Conditions: []corev1.PodCondition{
+						{
+							Type:               corev1.PodReady,
+							Status:             corev1.ConditionFalse,
+							LastTransitionTime: metav1.NewTime(ts.Add(-time.Minute * 2)),
+						},
+					},
+				},
+			},
+			orphaned:   0,
+			pending:    0,
+			evicted:    0,
+			successful: 0,
+			failed:     time.Minute,
+			expected:   false,
+		},
+		"annotation enables deletion of orphaned pods": {
+			podSpec: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						deleteOrphanedAfterAnnotation: "1m",
+					},
+				},
+				Status: corev1.PodStatus{
+					Conditions: []corev1.PodCondition{
+						{
+							Type:               corev1.PodReady,
+							Status:             corev1.ConditionFalse,
+							LastTransitionTime: metav1.NewTime(ts.Add(-time.Minute * 2)),
+						},
+					},
+				},
+			},
+			orphaned:   0,
+			pending:    0,
+			evicted:    0,
+			successful: 0,
+			failed:     0,
+			expected:   true,
+		},
 	}
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
@@ -162,3 +237,221 @@ func TestKleaner_DeletePod(t *testing.T) {
 		})
 	}
 }
+
+func TestWaitingPodExpiry(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	createWaitingPod := func(owner, reason string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(ts.Add(-time.Hour))},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(ts.Add(-time.Minute))},
+				},
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "sidecar", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+					{Name: "main", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}},
+				},
+			},
+		}
+		if owner != "" {
+			pod.OwnerReferences = []metav1.OwnerReference{{Kind: owner, Name: "owner"}}
+		}
+		return pod
+	}
+	durations := map[string]time.Duration{"CrashLoopBackOff": time.Hour, "ImagePullBackOff": 10 * time.Minute}
+	testCases := map[string]struct {
+		pod        *corev1.Pod
+		anyOwner   bool
+		expiry     time.Time
+		expectedOk bool
+	}{
+		"pod of job is deleted after the duration of the reason": {
+			pod:        createWaitingPod("Job", "CrashLoopBackOff"),
+			expiry:     ts.Add(59 * time.Minute),
+			expectedOk: true,
+		},
+		"orphaned pod is deleted after the duration of the reason": {
+			pod:        createWaitingPod("", "ImagePullBackOff"),
+			expiry:     ts.Add(9 * time.Minute),
+			expectedOk: true,
+		},
+		"pod waiting for other reason is kept": {
+			pod: createWaitingPod("Job", "ContainerCreating"),
+		},
+		"pod of replicaset is kept by default": {
+			pod: createWaitingPod("ReplicaSet", "CrashLoopBackOff"),
+		},
+		"pod of replicaset is deleted with any owner": {
+			pod:        createWaitingPod("ReplicaSet", "CrashLoopBackOff"),
+			anyOwner:   true,
+			expiry:     ts.Add(59 * time.Minute),
+			expectedOk: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			expiry, ok := waitingPodExpiry(tc.pod, durations, tc.anyOwner)
+			if ok != tc.expectedOk || !expiry.Equal(tc.expiry) {
+				t.Fatalf("failed, expected %v %v, got %v %v", tc.expiry, tc.expectedOk, expiry, ok)
+			}
+		})
+	}
+}
+
+func TestPendingPodExpiry(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	createPendingPod := func(annotations map[string]string, conditions ...corev1.PodCondition) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending, Conditions: conditions},
+		}
+	}
+	scheduled := corev1.PodCondition{Type: corev1.PodScheduled, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(ts.Add(-time.Hour))}
+	unschedulable := corev1.PodCondition{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(ts.Add(-time.Hour))}
+	initializing := corev1.PodCondition{Type: corev1.PodInitialized, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(ts.Add(-30 * time.Minute))}
+	initialized := corev1.PodCondition{Type: corev1.PodInitialized, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(ts.Add(-10 * time.Minute))}
+	testCases := map[string]struct {
+		pod           *corev1.Pod
+		expectedState string
+		expiry        time.Time
+		expectedOk    bool
+	}{
+		"unschedulable pod is left to delete-pending-pods-after": {
+			pod:           createPendingPod(nil, unschedulable),
+			expectedState: pendingUnschedulable,
+		},
+		"initializing pod is deleted after its duration": {
+			pod:           createPendingPod(nil, scheduled, initializing),
+			expectedState: pendingInitializing,
+			expiry:        ts.Add(30 * time.Minute),
+			expectedOk:    true,
+		},
+		"creating pod is deleted after its duration": {
+			pod:           createPendingPod(nil, scheduled, initialized),
+			expectedState: pendingCreating,
+			expiry:        ts.Add(5 * time.Minute),
+			expectedOk:    true,
+		},
+		"annotation overrides the duration of creating pod": {
+			pod:           createPendingPod(map[string]string{deleteCreatingAfterAnnotation: "1h"}, scheduled, initialized),
+			expectedState: pendingCreating,
+			expiry:        ts.Add(50 * time.Minute),
+			expectedOk:    true,
+		},
+		"pod without conditions is kept": {
+			pod: createPendingPod(nil),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if state, _ := pendingState(tc.pod); state != tc.expectedState {
+				t.Fatalf("failed, expected state %v, got %v", tc.expectedState, state)
+			}
+			expiry, ok := pendingPodExpiry(tc.pod, 15*time.Minute, time.Hour)
+			if ok != tc.expectedOk || !expiry.Equal(tc.expiry) {
+				t.Fatalf("failed, expected %v %v, got %v %v", tc.expiry, tc.expectedOk, expiry, ok)
+			}
+		})
+	}
+}
+
+func TestPodEvictionTime(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	disruption := corev1.PodCondition{Type: corev1.DisruptionTarget, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(ts.Add(-time.Hour))}
+	terminated := func(finishedAt time.Time) corev1.ContainerStatus {
+		return corev1.ContainerStatus{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(finishedAt)}}}
+	}
+	testCases := map[string]struct {
+		pod      *corev1.Pod
+		expected time.Time
+	}{
+		"disruption target condition is preferred": {
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{evictedAtAnnotation: ts.Format(time.RFC3339)}},
+				Status: corev1.PodStatus{
+					Conditions:        []corev1.PodCondition{disruption},
+					ContainerStatuses: []corev1.ContainerStatus{terminated(ts.Add(-time.Minute))},
+				},
+			},
+			expected: ts.Add(-time.Hour),
+		},
+		"last container termination is used without the condition": {
+			pod: &corev1.Pod{
+				Status: corev1.PodStatus{
+					InitContainerStatuses: []corev1.ContainerStatus{terminated(ts.Add(-time.Hour))},
+					ContainerStatuses:     []corev1.ContainerStatus{terminated(ts.Add(-time.Minute)), terminated(ts.Add(-time.Minute * 2))},
+				},
+			},
+			expected: ts.Add(-time.Minute),
+		},
+		"annotation is used without other timestamps": {
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{evictedAtAnnotation: ts.Add(-time.Minute * 5).Format(time.RFC3339)}},
+			},
+			expected: ts.Add(-time.Minute * 5),
+		},
+		"invalid annotation is ignored": {
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{evictedAtAnnotation: "yesterday"}},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := podEvictionTime(tc.pod)
+			if !result.Equal(tc.expected) {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestTerminatedPodExpiry(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	createFailedPod := func(reason string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web"}}},
+			Status: corev1.PodStatus{
+				Phase:  corev1.PodFailed,
+				Reason: reason,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.DisruptionTarget, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(ts.Add(-time.Minute))},
+				},
+			},
+		}
+	}
+	durations := map[string]time.Duration{"NodeShutdown": time.Hour, "Preempting": 0, "Evicted": time.Hour}
+	testCases := map[string]struct {
+		pod        *corev1.Pod
+		expiry     time.Time
+		expectedOk bool
+	}{
+		"pod of replicaset is deleted after the duration of the reason": {
+			pod:        createFailedPod("NodeShutdown"),
+			expiry:     ts.Add(59 * time.Minute),
+			expectedOk: true,
+		},
+		"pod without termination time is deleted right away": {
+			pod:        &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "NodeShutdown"}},
+			expectedOk: true,
+		},
+		"pod with zero duration is kept": {
+			pod: createFailedPod("Preempting"),
+		},
+		"pod terminated for other reason is kept": {
+			pod: createFailedPod("OutOfcpu"),
+		},
+		"evicted pod is left to delete-evicted-pods-after": {
+			pod: createFailedPod("Evicted"),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			expiry, ok := terminatedPodExpiry(tc.pod, durations)
+			if ok != tc.expectedOk || !expiry.Equal(tc.expiry) {
+				t.Fatalf("failed, expected %v %v, got %v %v", tc.expiry, tc.expectedOk, expiry, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	cleanupPolicyResource = schema.GroupVersionResource{
+		Group:    "cleanup.lwolf.org",
+		Version:  "v1alpha1",
+		Resource: "cleanuppolicies",
+	}
+	clusterCleanupPolicyResource = schema.GroupVersionResource{
+		Group:    "cleanup.lwolf.org",
+		Version:  "v1alpha1",
+		Resource: "clustercleanuppolicies",
+	}
+)
+
+// retention is a set of thresholds used to decide whether a Job or a Pod should be deleted
+type retention struct {
+	deleteSuccessfulAfter time.Duration
+	deleteFailedAfter     time.Duration
+	deletePendingAfter    time.Duration
+	deleteOrphanedAfter   time.Duration
+	deleteEvictedAfter    time.Duration
+	// deleteCreatingAfter and deleteInitializingAfter apply to scheduled Pending pods,
+	// deletePendingAfter only to unschedulable ones
+	deleteCreatingAfter     time.Duration
+	deleteInitializingAfter time.Duration
+	// deleteWaitingAfter holds durations by the waiting reason of containers, it is shared and must not be modified
+	deleteWaitingAfter map[string]time.Duration
+	// deleteTerminatedAfter holds durations by the reason Failed pods were terminated for, shared as well
+	deleteTerminatedAfter map[string]time.Duration
+
+	ignoreOwnedByCronjob bool
+
+	// number of the most recent finished jobs kept per group, 0 - time based retention
+	keepLastSuccessful int
+	keepLastFailed     int
+
+	// condition has to be met in addition to the thresholds, nil - no extra condition
+	condition *expression
+}
+
+// CleanupPolicySpec holds retention settings for Jobs and Pods matching the selector.
+// Fields that are not set fall back to the operator defaults.
+type CleanupPolicySpec struct {
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	DeleteSuccessfulAfter       *metav1.Duration `json:"deleteSuccessfulAfter,omitempty"`
+	DeleteFailedAfter           *metav1.Duration `json:"deleteFailedAfter,omitempty"`
+	DeletePendingPodsAfter      *metav1.Duration `json:"deletePendingPodsAfter,omitempty"`
+	DeleteOrphanedPodsAfter     *metav1.Duration `json:"deleteOrphanedPodsAfter,omitempty"`
+	DeleteEvictedPodsAfter      *metav1.Duration `json:"deleteEvictedPodsAfter,omitempty"`
+	DeleteCreatingPodsAfter     *metav1.Duration `json:"deleteCreatingPodsAfter,omitempty"`
+	DeleteInitializingPodsAfter *metav1.Duration `json:"deleteInitializingPodsAfter,omitempty"`
+	// DeleteWaitingPodsAfter overrides the durations of the listed waiting reasons, the rest are kept
+	DeleteWaitingPodsAfter map[string]metav1.Duration `json:"deleteWaitingPodsAfter,omitempty"`
+	// DeleteTerminatedPodsAfter overrides the durations of the listed termination reasons, the rest are kept
+	DeleteTerminatedPodsAfter map[string]metav1.Duration `json:"deleteTerminatedPodsAfter,omitempty"`
+
+	IgnoreOwnedByCronjobs *bool `json:"ignoreOwnedByCronjobs,omitempty"`
+
+	// KeepLastSuccessfulJobs and KeepLastFailedJobs switch jobs to count based retention, 0 - time based retention
+	KeepLastSuccessfulJobs *int32 `json:"keepLastSuccessfulJobs,omitempty"`
+	KeepLastFailedJobs     *int32 `json:"keepLastFailedJobs,omitempty"`
+
+	// Condition is a CEL expression evaluated against the Job or Pod available as `object`,
+	// matching objects are deleted only if it returns true
+	Condition string `json:"condition,omitempty"`
+}
+
+// CleanupPolicy represents both namespaced CleanupPolicy and ClusterCleanupPolicy objects
+type CleanupPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec CleanupPolicySpec `json:"spec"`
+}
+
+// cachedPolicy is stored in the policy informers instead of the unstructured object,
+// so selectors are parsed once instead of on every lookup
+type cachedPolicy struct {
+	*CleanupPolicy
+	selector  labels.Selector
+	condition *expression
+}
+
+// apply overrides the thresholds and the condition of r with the ones set in the policy
+func (p *cachedPolicy) apply(r retention) retention {
+	r = p.Spec.apply(r)
+	if p.condition != nil {
+		r.condition = p.condition
+	}
+	return r
+}
+
+// apply overrides the thresholds of r with the ones set in the policy
+func (s *CleanupPolicySpec) apply(r retention) retention {
+	if s.DeleteSuccessfulAfter != nil {
+		r.deleteSuccessfulAfter = s.DeleteSuccessfulAfter.Duration
+	}
+	if s.DeleteFailedAfter != nil {
+		r.deleteFailedAfter = s.DeleteFailedAfter.Duration
+	}
+	if s.DeletePendingPodsAfter != nil {
+		r.deletePendingAfter = s.DeletePendingPodsAfter.Duration
+	}
+	if s.DeleteOrphanedPodsAfter != nil {
+		r.deleteOrphanedAfter = s.DeleteOrphanedPodsAfter.Duration
+	}
+	if s.DeleteEvictedPodsAfter != nil {
+		r.deleteEvictedAfter = s.DeleteEvictedPodsAfter.Duration
+	}
+	if s.DeleteCreatingPodsAfter != nil {
+		r.deleteCreatingAfter = s.DeleteCreatingPodsAfter.Duration
+	}
+	if s.DeleteInitializingPodsAfter != nil {
+		r.deleteInitializingAfter = s.DeleteInitializingPodsAfter.Duration
+	}
+	r.deleteWaitingAfter = mergeDurations(r.deleteWaitingAfter, s.DeleteWaitingPodsAfter)
+	r.deleteTerminatedAfter = mergeDurations(r.deleteTerminatedAfter, s.DeleteTerminatedPodsAfter)
+	if s.IgnoreOwnedByCronjobs != nil {
+		r.ignoreOwnedByCronjob = *s.IgnoreOwnedByCronjobs
+	}
+	if s.KeepLastSuccessfulJobs != nil {
+		r.keepLastSuccessful = int(*s.KeepLastSuccessfulJobs)
+	}
+	if s.KeepLastFailedJobs != nil {
+		r.keepLastFailed = int(*s.KeepLastFailedJobs)
+	}
+	return r
+}
+
+// policyFromUnstructured converts objects received from the dynamic client into cachedPolicy.
+// It never fails, an error of an informer transform would stop the informer from ever syncing.
+func policyFromUnstructured(obj interface{}) (interface{}, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		// cache.DeletedFinalStateUnknown and already converted objects
+		return obj, nil
+	}
+	policy := &CleanupPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, policy); err != nil {
+		// e.g. a duration accepted by the apiserver but not by time.ParseDuration
+		log.Printf("%s '%s' is invalid, objects it matches will not be deleted: %v", u.GetKind(), u.GetName(), err)
+		return invalidPolicy(u), nil
+	}
+	cached := &cachedPolicy{CleanupPolicy: policy, selector: labels.Everything()}
+	if policy.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.Selector)
+		if err != nil {
+			log.Printf("%s '%s' has an invalid selector, it will be ignored: %v", u.GetKind(), u.GetName(), err)
+			selector = labels.Nothing()
+		}
+		cached.selector = selector
+	}
+	if policy.Spec.Condition != "" {
+		condition, err := newExpression(policy.Spec.Condition)
+		if err != nil {
+			// an expression without program matches nothing, so the policy keeps its objects instead of
+			// letting them be deleted by the less specific thresholds
+			log.Printf("%s '%s' has an invalid condition, objects it matches will not be deleted: %v", u.GetKind(), u.GetName(), err)
+			condition = &expression{source: policy.Spec.Condition}
+		}
+		cached.condition = condition
+	}
+	return cached, nil
+}
+
+// invalidPolicy returns a policy that keeps the objects it selects, so a policy that can not be converted does not let
+// them be deleted by the less specific thresholds. It selects everything if its selector can not be converted either.
+func invalidPolicy(u *unstructured.Unstructured) *cachedPolicy {
+	policy := &CleanupPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: u.GetAPIVersion(), Kind: u.GetKind()},
+		ObjectMeta: metav1.ObjectMeta{Namespace: u.GetNamespace(), Name: u.GetName(), Labels: u.GetLabels()},
+	}
+	// an expression without program matches nothing
+	cached := &cachedPolicy{CleanupPolicy: policy, selector: labels.Everything(), condition: &expression{}}
+	if raw, found, _ := unstructured.NestedMap(u.Object, "spec", "selector"); found {
+		selector := &metav1.LabelSelector{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, selector); err == nil {
+			if parsed, err := metav1.LabelSelectorAsSelector(selector); err == nil {
+				policy.Spec.Selector = selector
+				cached.selector = parsed
+			}
+		}
+	}
+	return cached
+}
+
+func newPolicyInformer(dclient dynamic.Interface, gvr schema.GroupVersionResource, namespace string) cache.SharedIndexInformer {
+	informer := dynamicinformer.NewFilteredDynamicInformer(
+		dclient,
+		gvr,
+		namespace,
+		resyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		nil,
+	).Informer()
+	if err := informer.SetTransform(policyFromUnstructured); err != nil {
+		log.Fatalf("failed to set transform on %s informer: %v", gvr.Resource, err)
+	}
+	return informer
+}
+
+// mostSpecificPolicy returns the policy whose selector matches the given labels and has the largest
+// number of requirements. Ties are broken by name to keep the choice stable. Returns nil if nothing matches.
+func mostSpecificPolicy(policies []interface{}, lbls labels.Set) *cachedPolicy {
+	var (
+		best            *cachedPolicy
+		bestSpecificity int
+	)
+	for _, obj := range policies {
+		policy, ok := obj.(*cachedPolicy)
+		if !ok || !policy.selector.Matches(lbls) {
+			continue
+		}
+		requirements, _ := policy.selector.Requirements()
+		specificity := len(requirements)
+		if best == nil || specificity > bestSpecificity || (specificity == bestSpecificity && policy.Name < best.Name) {
+			best = policy
+			bestSpecificity = specificity
+		}
+	}
+	return best
+}
+
+// mergeDurations returns a copy of the durations by reason with the overrides applied,
+// durations itself is returned if there are no overrides
+func mergeDurations(durations map[string]time.Duration, overrides map[string]metav1.Duration) map[string]time.Duration {
+	if len(overrides) == 0 {
+		return durations
+	}
+	result := make(map[string]time.Duration, len(durations)+len(overrides))
+	for reason, d := range durations {
+		result[reason] = d
+	}
+	for reason, d := range overrides {
+		result[reason] = d.Duration
+	}
+	return result
+}
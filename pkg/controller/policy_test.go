@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+func createPolicy(t *testing.T, name string, spec map[string]interface{}) *cachedPolicy {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cleanup.lwolf.org/v1alpha1",
+		"kind":       "CleanupPolicy",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       spec,
+	}}
+	obj, err := policyFromUnstructured(u)
+	if err != nil {
+		t.Fatalf("failed to convert policy: %v", err)
+	}
+	return obj.(*cachedPolicy)
+}
+
+func TestPolicyFromUnstructured(t *testing.T) {
+	policy := createPolicy(t, "p", map[string]interface{}{
+		"selector":                  map[string]interface{}{"matchLabels": map[string]interface{}{"app": "etl"}},
+		"deleteFailedAfter":         "168h",
+		"ignoreOwnedByCronjobs":     true,
+		"deleteWaitingPodsAfter":    map[string]interface{}{"CrashLoopBackOff": "2h"},
+		"deleteTerminatedPodsAfter": map[string]interface{}{"NodeShutdown": "1h"},
+	})
+	defaults := retention{
+		deleteSuccessfulAfter: time.Minute,
+		deleteFailedAfter:     time.Minute,
+		deleteWaitingAfter:    map[string]time.Duration{"CrashLoopBackOff": time.Hour, "ImagePullBackOff": time.Hour},
+	}
+	r := policy.Spec.apply(defaults)
+	expected := retention{
+		deleteSuccessfulAfter: time.Minute,
+		deleteFailedAfter:     168 * time.Hour,
+		deleteWaitingAfter:    map[string]time.Duration{"CrashLoopBackOff": 2 * time.Hour, "ImagePullBackOff": time.Hour},
+		deleteTerminatedAfter: map[string]time.Duration{"NodeShutdown": time.Hour},
+		ignoreOwnedByCronjob:  true,
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("failed, expected %+v, got %+v", expected, r)
+	}
+	if !policy.selector.Matches(labels.Set{"app": "etl"}) || policy.selector.Matches(labels.Set{"app": "web"}) {
+		t.Fatalf("failed, selector %s was not parsed correctly", policy.selector)
+	}
+}
+
+func TestPolicyFromUnstructured_condition(t *testing.T) {
+	pod := &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed}}
+	policy := createPolicy(t, "p", map[string]interface{}{
+		"condition": `object.status.phase == "Failed"`,
+	})
+	r := policy.apply(retention{})
+	if r.condition == nil || !r.condition.matches(pod) {
+		t.Fatalf("failed, expected condition to match")
+	}
+	invalid := createPolicy(t, "invalid", map[string]interface{}{
+		"condition": `object.status.phase ==`,
+	})
+	r = invalid.apply(retention{})
+	if r.condition == nil || r.condition.matches(pod) {
+		t.Fatalf("failed, expected invalid condition to match nothing")
+	}
+}
+
+func TestPolicyFromUnstructured_invalid(t *testing.T) {
+	pod := &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed}}
+	policy := createPolicy(t, "typo", map[string]interface{}{
+		"selector":          map[string]interface{}{"matchLabels": map[string]interface{}{"app": "etl"}},
+		"deleteFailedAfter": "2 hours",
+	})
+	if policy.Name != "typo" || !policy.selector.Matches(labels.Set{"app": "etl"}) || policy.selector.Matches(labels.Set{"app": "web"}) {
+		t.Fatalf("failed, expected the policy to keep its name and selector, got %s %s", policy.Name, policy.selector)
+	}
+	r := policy.apply(retention{deleteFailedAfter: time.Minute})
+	if r.condition == nil || r.condition.matches(pod) {
+		t.Fatalf("failed, expected invalid policy to delete nothing")
+	}
+}
+
+func TestMostSpecificPolicy(t *testing.T) {
+	all := createPolicy(t, "all", map[string]interface{}{})
+	etl := createPolicy(t, "etl", map[string]interface{}{
+		"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "etl"}},
+	})
+	etlBackfill := createPolicy(t, "etl-backfill", map[string]interface{}{
+		"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "etl", "type": "backfill"}},
+	})
+	etlTwin := createPolicy(t, "a-etl", map[string]interface{}{
+		"selector": map[string]interface{}{"matchExpressions": []interface{}{
+			map[string]interface{}{"key": "app", "operator": "In", "values": []interface{}{"etl"}},
+		}},
+	})
+	testCases := map[string]struct {
+		policies []interface{}
+		labels   labels.Set
+		expected *cachedPolicy
+	}{
+		"no policies": {
+			policies: nil,
+			labels:   labels.Set{"app": "etl"},
+			expected: nil,
+		},
+		"policy without selector matches everything": {
+			policies: []interface{}{all, etl},
+			labels:   labels.Set{"app": "web"},
+			expected: all,
+		},
+		"policy with more requirements wins": {
+			policies: []interface{}{all, etl, etlBackfill},
+			labels:   labels.Set{"app": "etl", "type": "backfill"},
+			expected: etlBackfill,
+		},
+		"non matching policies are skipped": {
+			policies: []interface{}{etl, etlBackfill},
+			labels:   labels.Set{"app": "etl"},
+			expected: etl,
+		},
+		"ties are broken by name": {
+			policies: []interface{}{etl, etlTwin},
+			labels:   labels.Set{"app": "etl"},
+			expected: etlTwin,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := mostSpecificPolicy(tc.policies, tc.labels)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestKleaner_retentionFor(t *testing.T) {
+	newStore := func(objs ...interface{}) cache.SharedIndexInformer {
+		informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, nil, 0,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		for _, obj := range objs {
+			if err := informer.GetIndexer().Add(obj); err != nil {
+				t.Fatalf("failed to add object: %v", err)
+			}
+		}
+		return informer
+	}
+	clusterPolicy := createPolicy(t, "cluster", map[string]interface{}{
+		"deleteSuccessfulAfter":  "1h",
+		"deleteFailedAfter":      "1h",
+		"deletePendingPodsAfter": "1h",
+	})
+	clusterPolicy.Namespace = ""
+	namespacedPolicy := createPolicy(t, "namespaced", map[string]interface{}{
+		"deleteFailedAfter": "3h",
+	})
+	kleaner := &Kleaner{
+		clusterPolicyInformer: newStore(clusterPolicy),
+		policyInformer:        newStore(namespacedPolicy),
+		namespaceInformer: newStore(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				deleteFailedAfterAnnotation:  "2h",
+				deletePendingAfterAnnotation: "2h",
+			},
+		}}),
+	}
+	current, err := newSettings(Config{
+		DeleteSuccessfulAfter:   metav1.Duration{Duration: time.Minute},
+		DeleteOrphanedPodsAfter: metav1.Duration{Duration: time.Minute},
+		DeleteEvictedPodsAfter:  metav1.Duration{Duration: time.Minute},
+		Policies: []ConfigPolicy{
+			{
+				Namespaces: []string{"def*"},
+				CleanupPolicySpec: CleanupPolicySpec{
+					DeleteEvictedPodsAfter: &metav1.Duration{Duration: time.Second},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create settings: %v", err)
+	}
+	kleaner.settings.Store(current)
+	testCases := map[string]struct {
+		namespace string
+		expected  retention
+	}{
+		"all overrides are applied in the namespace of the policy": {
+			namespace: "default",
+			expected: retention{
+				deleteSuccessfulAfter: time.Hour,
+				deleteFailedAfter:     3 * time.Hour,
+				deletePendingAfter:    2 * time.Hour,
+				deleteOrphanedAfter:   time.Minute,
+				deleteEvictedAfter:    time.Second,
+			},
+		},
+		"only cluster policy is applied in other namespaces": {
+			namespace: "other",
+			expected: retention{
+				deleteSuccessfulAfter: time.Hour,
+				deleteFailedAfter:     time.Hour,
+				deletePendingAfter:    time.Hour,
+				deleteOrphanedAfter:   time.Minute,
+				deleteEvictedAfter:    time.Minute,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := kleaner.retentionFor(&metav1.ObjectMeta{Namespace: tc.namespace})
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Fatalf("failed, expected %+v, got %+v", tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"log"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podJobIndex indexes pods of the pod informer by the namespace/name of the Job owning them
+const podJobIndex = "job"
+
+// queueKey identifies a Job, a Pod or an object of a custom resource in the queue, objects are taken
+// from the informer stores when processed. The kind of custom resources is their resourceKey.
+type queueKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+func (k queueKey) storeKey() string {
+	return k.namespace + "/" + k.name
+}
+
+func podJobIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || !isOwnedByJob(getPodOwnerKinds(pod)) {
+		return nil, nil
+	}
+	return []string{pod.Namespace + "/" + pod.OwnerReferences[0].Name}, nil
+}
+
+func (c *Kleaner) enqueue(obj interface{}) {
+	switch t := obj.(type) {
+	case *batchv1.Job:
+		c.queue.Add(queueKey{kind: "Job", namespace: t.Namespace, name: t.Name})
+	case *corev1.Pod:
+		c.queue.Add(queueKey{kind: "Pod", namespace: t.Namespace, name: t.Name})
+	}
+}
+
+// enqueueJob enqueues the job together with the objects whose retention depends on it:
+// its pods and, once it has finished, the jobs of its history group
+func (c *Kleaner) enqueueJob(obj interface{}) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	c.enqueue(job)
+	informers := c.informersOf(job.Namespace)
+	if informers == nil {
+		return
+	}
+	c.enqueueIndexed(informers.pods, podJobIndex, job.Namespace+"/"+job.Name)
+	if finished, _ := jobOutcome(job); finished {
+		if group := jobHistoryGroup(job, c.config.JobHistoryGroupLabel); group != "" {
+			c.enqueueIndexed(informers.jobs, jobHistoryIndex, group)
+		}
+	}
+}
+
+func (c *Kleaner) enqueueIndexed(informer cache.SharedIndexInformer, index, value string) {
+	objs, err := informer.GetIndexer().ByIndex(index, value)
+	if err != nil {
+		log.Printf("failed to list objects by %s '%s': %v", index, value, err)
+		return
+	}
+	for _, obj := range objs {
+		c.enqueue(obj)
+	}
+}
+
+// enqueueNamespace enqueues all jobs and pods of the namespace, e.g. after its retention overrides changed
+func (c *Kleaner) enqueueNamespace(namespace string) {
+	if informers := c.informersOf(namespace); informers != nil {
+		c.enqueueIndexed(informers.jobs, cache.NamespaceIndex, namespace)
+		c.enqueueIndexed(informers.pods, cache.NamespaceIndex, namespace)
+	}
+}
+
+// enqueueNamespaceOf enqueues the namespace of a namespaced object, e.g. a CleanupPolicy
+func (c *Kleaner) enqueueNamespaceOf(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		namespace, _, err := cache.SplitMetaNamespaceKey(tombstone.Key)
+		if err == nil {
+			c.enqueueNamespace(namespace)
+		}
+		return
+	}
+	if m, err := meta.Accessor(obj); err == nil {
+		c.enqueueNamespace(m.GetNamespace())
+	}
+}
+
+// enqueueAll enqueues every job, pod and object of the custom resources, e.g. after the configuration changed
+func (c *Kleaner) enqueueAll() {
+	for _, informers := range c.listInformers() {
+		for _, obj := range informers.jobs.GetStore().List() {
+			c.enqueue(obj)
+		}
+		for _, obj := range informers.pods.GetStore().List() {
+			c.enqueue(obj)
+		}
+		for key, informer := range informers.resources {
+			for _, obj := range informer.GetStore().List() {
+				c.enqueueResource(key, obj)
+			}
+		}
+	}
+}
+
+func (c *Kleaner) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+// processNextItem processes a single object of the queue and schedules it for the time it expires at.
+// Returns false when the queue is shut down.
+func (c *Kleaner) processNextItem() bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(queueKey)
+	if c.scope.excludeOnly() && !c.scope.contains(key.namespace, nil) {
+		// objects of excluded namespaces are seen by the cluster-wide informers, but never processed
+		c.queue.Forget(item)
+		return true
+	}
+	informers := c.informersOf(key.namespace)
+	if informers == nil {
+		// objects of namespaces assigned to other replicas are forgotten
+		c.queue.Forget(item)
+		return true
+	}
+	if !informers.hasSynced() {
+		// pods are processed together with their jobs, so both have to be known
+		c.queue.AddAfter(item, time.Second)
+		return true
+	}
+	informer := informers.informerOf(key.kind)
+	if informer == nil {
+		// objects of resources that are no longer watched are forgotten
+		c.queue.Forget(item)
+		return true
+	}
+	obj, exists, err := informer.GetStore().GetByKey(key.storeKey())
+	if err != nil || !exists {
+		// deleted objects are forgotten
+		c.queue.Forget(item)
+		return true
+	}
+	var next time.Time
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		next, err = c.ProcessResource(key.kind, u)
+	} else {
+		next, err = c.Process(obj)
+	}
+	if err != nil {
+		c.queue.AddRateLimited(item)
+		return true
+	}
+	c.queue.Forget(item)
+	if !next.IsZero() {
+		c.queue.AddAfter(item, time.Until(next))
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func createTestKleaner(t *testing.T, cfg Config, objs ...interface{}) *Kleaner {
+	jobs, pods := &batchv1.JobList{}, &corev1.PodList{}
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *batchv1.Job:
+			jobs.Items = append(jobs.Items, *o)
+		case *corev1.Pod:
+			pods.Items = append(pods.Items, *o)
+		}
+	}
+	newListWatch := func(list runtime.Object) *cache.ListWatch {
+		return &cache.ListWatch{
+			ListFunc:  func(metav1.ListOptions) (runtime.Object, error) { return list, nil },
+			WatchFunc: func(metav1.ListOptions) (watch.Interface, error) { return watch.NewFake(), nil },
+		}
+	}
+	informers := &objectInformers{
+		jobs: cache.NewSharedIndexInformer(newListWatch(jobs), &batchv1.Job{}, 0, cache.Indexers{
+			cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			jobHistoryIndex:      jobHistoryIndexFunc(cfg.JobHistoryGroupLabel),
+		}),
+		pods: cache.NewSharedIndexInformer(newListWatch(pods), &corev1.Pod{}, 0, cache.Indexers{
+			cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			podJobIndex:          podJobIndexFunc,
+		}),
+		stopCh: make(chan struct{}),
+	}
+	informers.run()
+	t.Cleanup(func() { close(informers.stopCh) })
+	if !cache.WaitForCacheSync(informers.stopCh, informers.hasSynced) {
+		t.Fatalf("failed to sync informers")
+	}
+	kleaner := &Kleaner{
+		informers: map[string]*objectInformers{metav1.NamespaceAll: informers},
+		queue:     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		config:    cfg,
+	}
+	t.Cleanup(kleaner.queue.ShutDown)
+	current, err := newSettings(cfg)
+	if err != nil {
+		t.Fatalf("failed to create settings: %v", err)
+	}
+	kleaner.settings.Store(current)
+	return kleaner
+}
+
+func TestKleaner_Process(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	keepUntilTime := ts.Add(time.Hour)
+
+	finished := createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{})
+	finished.Namespace, finished.Name = "default", "finished"
+	expired := createJob(false, ts.Add(-time.Hour), 0, 1, 0, []batchv1.JobCondition{})
+	expired.Namespace, expired.Name = "default", "expired"
+	running := createJob(false, time.Time{}, 1, 0, 0, []batchv1.JobCondition{})
+	running.Status.CompletionTime = nil
+	protected := withJobAnnotations(createJob(false, ts.Add(-time.Hour), 0, 1, 0, []batchv1.JobCondition{}),
+		map[string]string{keepUntilAnnotation: keepUntilTime.Format(time.RFC3339)})
+	protected.Namespace, protected.Name = "default", "protected"
+	pending := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pending"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(ts.Add(-time.Minute))},
+			},
+		},
+	}
+	podOfProtected := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "protected-pod",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "protected"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+	}
+	kleaner := createTestKleaner(t, Config{
+		DryRun:                 true,
+		DeleteSuccessfulAfter:  metav1.Duration{Duration: 15 * time.Minute},
+		DeletePendingPodsAfter: metav1.Duration{Duration: 10 * time.Minute},
+	}, protected)
+
+	testCases := map[string]struct {
+		obj      interface{}
+		expected time.Time
+	}{
+		"job is scheduled for its expiry": {
+			obj:      finished,
+			expected: ts.Add(14 * time.Minute),
+		},
+		"expired job is deleted": {
+			obj:      expired,
+			expected: time.Time{},
+		},
+		"running job waits for changes": {
+			obj:      running,
+			expected: time.Time{},
+		},
+		"protected job is scheduled for the end of protection": {
+			obj:      protected,
+			expected: keepUntilTime,
+		},
+		"pending pod is scheduled for its expiry": {
+			obj:      pending,
+			expected: ts.Add(9 * time.Minute),
+		},
+		"pod of protected job is scheduled for the end of protection": {
+			obj:      podOfProtected,
+			expected: keepUntilTime,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, err := kleaner.Process(tc.obj)
+			if err != nil {
+				t.Fatalf("failed to process: %v", err)
+			}
+			if !result.Equal(tc.expected) {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestKleaner_enqueueJob(t *testing.T) {
+	controller := true
+	job := createFinishedJob("backup-2", false, time.Now())
+	sibling := createFinishedJob("backup-1", false, time.Now().Add(-time.Hour))
+	other := createFinishedJob("other", false, time.Now())
+	other.OwnerReferences = []metav1.OwnerReference{{Kind: "CronJob", Name: "other", Controller: &controller}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       "default",
+		Name:            "backup-2-abcde",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "backup-2"}},
+	}}
+	kleaner := createTestKleaner(t, Config{}, job, sibling, other, pod)
+
+	kleaner.enqueueJob(job)
+	expected := map[queueKey]bool{
+		{kind: "Job", namespace: "default", name: "backup-2"}:       true,
+		{kind: "Job", namespace: "default", name: "backup-1"}:       true,
+		{kind: "Pod", namespace: "default", name: "backup-2-abcde"}: true,
+	}
+	if kleaner.queue.Len() != len(expected) {
+		t.Fatalf("failed, expected %d queued objects, got %d", len(expected), kleaner.queue.Len())
+	}
+	for kleaner.queue.Len() > 0 {
+		item, _ := kleaner.queue.Get()
+		if !expected[item.(queueKey)] {
+			t.Fatalf("failed, unexpected object %v", item)
+		}
+		kleaner.queue.Done(item)
+	}
+}
+
+func TestKleaner_processNextItem(t *testing.T) {
+	job := createJob(false, time.Now().Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{})
+	job.Namespace, job.Name = "default", "finished"
+	kleaner := createTestKleaner(t, Config{DeleteSuccessfulAfter: metav1.Duration{Duration: time.Hour}}, job)
+
+	kleaner.enqueue(job)
+	kleaner.queue.Add(queueKey{kind: "Pod", namespace: "default", name: "deleted"})
+	for i := 0; i < 2; i++ {
+		if !kleaner.processNextItem() {
+			t.Fatalf("failed, queue is not expected to be shut down")
+		}
+	}
+	// the job is waiting for its expiry in an hour, the deleted pod is forgotten
+	if kleaner.queue.Len() != 0 {
+		t.Fatalf("failed, expected empty queue, got %d", kleaner.queue.Len())
+	}
+	kleaner.queue.ShutDown()
+	if kleaner.processNextItem() {
+		t.Fatalf("failed, queue is expected to be shut down")
+	}
+}
+
+func TestKleaner_processNextItemExcludedNamespace(t *testing.T) {
+	job := createJob(false, time.Now().Add(-time.Hour), 0, 1, 0, []batchv1.JobCondition{})
+	job.Namespace, job.Name = "kube-system", "finished"
+	cfg := Config{ExcludeNamespaces: []string{"kube-*"}, DeleteSuccessfulAfter: metav1.Duration{Duration: time.Minute}}
+	kleaner := createTestKleaner(t, cfg, job)
+	scope, err := newNamespaceScope(cfg)
+	if err != nil {
+		t.Fatalf("failed to create scope: %v", err)
+	}
+	kleaner.scope = scope
+	if kleaner.watchesNamespaces() {
+		t.Fatalf("failed, namespaces are only excluded and must be watched cluster-wide")
+	}
+
+	// the Kleaner has no client, deleting the expired job would panic
+	kleaner.enqueue(job)
+	if !kleaner.processNextItem() {
+		t.Fatalf("failed, queue is not expected to be shut down")
+	}
+	if kleaner.queue.Len() != 0 {
+		t.Fatalf("failed, expected empty queue, got %d", kleaner.queue.Len())
+	}
+}
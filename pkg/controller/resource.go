@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	resourceDeletedMetric       = "resources_deleted_total"
+	resourceDeletedFailedMetric = "resources_deleted_failed_total"
+)
+
+// ResourceConfig selects completed objects of an arbitrary namespaced resource to delete, e.g. Argo Workflows
+// or Tekton PipelineRuns. The objects are watched with the dynamic client alongside Jobs and Pods.
+type ResourceConfig struct {
+	// Group, Version and Resource of the objects, e.g. `argoproj.io`, `v1alpha1` and `workflows`
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	// LabelSelector limits the watched objects, empty - all objects
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// CompletionTime is a JSONPath to the RFC3339 time the object completed at, e.g. `{.status.finishedAt}`.
+	// Objects without it are not complete and never deleted.
+	CompletionTime string `json:"completionTime"`
+	// Condition has to be present in `status.conditions` of the object for it to be deleted, nil - any object
+	Condition *ResourceCondition `json:"condition,omitempty"`
+	// DeleteAfter is the time the object is kept for after its completion, 0 - never delete
+	DeleteAfter metav1.Duration `json:"deleteAfter"`
+}
+
+// ResourceCondition matches a status condition by its type and status, empty status matches any status
+type ResourceCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status,omitempty"`
+}
+
+// resourceCleanup is the validated version of ResourceConfig
+type resourceCleanup struct {
+	ResourceConfig
+	gvr schema.GroupVersionResource
+}
+
+func newResourceCleanup(r ResourceConfig) (*resourceCleanup, error) {
+	if r.Version == "" || r.Resource == "" {
+		return nil, fmt.Errorf("version and resource can not be empty")
+	}
+	if _, err := labels.Parse(r.LabelSelector); err != nil {
+		return nil, fmt.Errorf("invalid label selector: %v", err)
+	}
+	if r.CompletionTime == "" {
+		return nil, fmt.Errorf("completionTime can not be empty")
+	}
+	if err := jsonpath.New(r.Resource).Parse(r.CompletionTime); err != nil {
+		return nil, fmt.Errorf("invalid completionTime '%s': %v", r.CompletionTime, err)
+	}
+	if r.Condition != nil && r.Condition.Type == "" {
+		return nil, fmt.Errorf("condition type can not be empty")
+	}
+	return &resourceCleanup{
+		ResourceConfig: r,
+		gvr:            schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource},
+	}, nil
+}
+
+// resourceKey identifies the resource in the queue and the informers, e.g. `workflows.argoproj.io`
+func resourceKey(r ResourceConfig) string {
+	return schema.GroupResource{Group: r.Group, Resource: r.Resource}.String()
+}
+
+// watchedResources returns the parts of the resources their informers depend on
+func watchedResources(resources []ResourceConfig) []string {
+	result := make([]string, 0, len(resources))
+	for _, r := range resources {
+		gvr := schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+		result = append(result, gvr.String()+" "+r.LabelSelector)
+	}
+	return result
+}
+
+// completedAt returns the completion time of the object, zero if it is not set or invalid
+func (r *resourceCleanup) completedAt(obj *unstructured.Unstructured) time.Time {
+	var buf bytes.Buffer
+	// parsed JSONPaths are not safe for concurrent use and objects are processed by several workers,
+	// so the path is parsed every time
+	completionTime := jsonpath.New(r.Resource)
+	if err := completionTime.Parse(r.CompletionTime); err != nil {
+		return time.Time{}
+	}
+	completionTime.AllowMissingKeys(true)
+	if err := completionTime.Execute(&buf, obj.Object); err != nil {
+		return time.Time{}
+	}
+	value := strings.TrimSpace(buf.String())
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Printf("invalid completion time '%s' of %s '%s:%s'", value, resourceKey(r.ResourceConfig), obj.GetNamespace(), obj.GetName())
+		return time.Time{}
+	}
+	return t
+}
+
+// hasCondition returns true if the object has the condition of the resource or no condition is required
+func (r *resourceCleanup) hasCondition(obj *unstructured.Unstructured) bool {
+	if r.Condition == nil {
+		return true
+	}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != r.Condition.Type {
+			continue
+		}
+		if r.Condition.Status == "" || condition["status"] == r.Condition.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// expiry returns the time the object has to be deleted at, ok is false if it is not complete
+// or does not have the condition
+func (r *resourceCleanup) expiry(obj *unstructured.Unstructured) (expiry time.Time, ok bool) {
+	if r.DeleteAfter.Duration <= 0 || !r.hasCondition(obj) {
+		return time.Time{}, false
+	}
+	completedAt := r.completedAt(obj)
+	if completedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return completedAt.Add(r.DeleteAfter.Duration), true
+}
+
+// newResourceInformer creates the informer of the resource in the namespace, empty - all namespaces
+func (c *Kleaner) newResourceInformer(r ResourceConfig, namespace string) cache.SharedIndexInformer {
+	key := resourceKey(r)
+	informer := dynamicinformer.NewFilteredDynamicInformer(
+		c.dclient,
+		schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource},
+		namespace,
+		resyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = r.LabelSelector
+		},
+	).Informer()
+	if err := informer.SetTransform(transformObject); err != nil {
+		log.Fatalf("failed to set transform of the %s informer: %v", key, err)
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.enqueueResource(key, obj) },
+		UpdateFunc: func(old, new interface{}) { c.enqueueResource(key, new) },
+	})
+	return informer
+}
+
+func (c *Kleaner) enqueueResource(key string, obj interface{}) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		c.queue.Add(queueKey{kind: key, namespace: u.GetNamespace(), name: u.GetName()})
+	}
+}
+
+// ProcessResource deletes the object of the resource if it has been complete for long enough. Otherwise it returns
+// the time the object has to be processed again, zero if it only has to be processed when it changes.
+func (c *Kleaner) ProcessResource(key string, obj *unstructured.Unstructured) (time.Time, error) {
+	resource, ok := c.settings.Load().resources[key]
+	if !ok {
+		// the resource was removed from the configuration
+		return time.Time{}, nil
+	}
+	if obj.GetDeletionTimestamp() != nil {
+		return time.Time{}, nil
+	}
+	if isProtected(obj.GetAnnotations(), time.Now()) {
+		return keepUntil(obj.GetAnnotations()), nil
+	}
+	expiry, ok := resource.expiry(obj)
+	return deleteWhenExpired(expiry, ok, func() error { return c.deleteResource(resource, obj) })
+}
+
+func (c *Kleaner) deleteResource(resource *resourceCleanup, obj *unstructured.Unstructured) error {
+	key := resourceKey(resource.ResourceConfig)
+	if c.settings.Load().dryRun {
+		log.Printf("dry-run: %s '%s:%s' would have been deleted", key, obj.GetNamespace(), obj.GetName())
+		return nil
+	}
+	log.Printf("Deleting %s '%s/%s'", key, obj.GetNamespace(), obj.GetName())
+	propagation := metav1.DeletePropagationBackground
+	do := metav1.DeleteOptions{PropagationPolicy: &propagation}
+	if err := c.dclient.Resource(resource.gvr).Namespace(obj.GetNamespace()).Delete(c.ctx, obj.GetName(), do); ignoreNotFound(err) != nil {
+		log.Printf("failed to delete %s '%s:%s': %v", key, obj.GetNamespace(), obj.GetName(), err)
+		metrics.GetOrCreateCounter(resourceMetricName(resourceDeletedFailedMetric, obj.GetNamespace(), key)).Inc()
+		return err
+	}
+	metrics.GetOrCreateCounter(resourceMetricName(resourceDeletedMetric, obj.GetNamespace(), key)).Inc()
+	return nil
+}
+
+func resourceMetricName(name, namespace, resource string) string {
+	return fmt.Sprintf(`%s{namespace=%q,resource=%q}`, name, namespace, resource)
+}
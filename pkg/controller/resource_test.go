@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func createPipelineRun(completionTime time.Time, status string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "tekton.dev/v1",
+		"kind":       "PipelineRun",
+		"metadata":   map[string]interface{}{"namespace": "ci", "name": "build-1"},
+		"spec":       map[string]interface{}{"pipelineRef": map[string]interface{}{"name": "build"}},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Succeeded", "status": status},
+			},
+		},
+	}}
+	if !completionTime.IsZero() {
+		_ = unstructured.SetNestedField(obj.Object, completionTime.Format(time.RFC3339), "status", "completionTime")
+	}
+	return obj
+}
+
+func TestResourceCleanup_expiry(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	resource, err := newResourceCleanup(ResourceConfig{
+		Group:          "tekton.dev",
+		Version:        "v1",
+		Resource:       "pipelineruns",
+		CompletionTime: "{.status.completionTime}",
+		Condition:      &ResourceCondition{Type: "Succeeded", Status: "True"},
+		DeleteAfter:    metav1.Duration{Duration: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+	testCases := map[string]struct {
+		obj        *unstructured.Unstructured
+		expiry     time.Time
+		expectedOk bool
+	}{
+		"completed object is deleted after the duration": {
+			obj:        createPipelineRun(ts.Add(-time.Minute), "True"),
+			expiry:     ts.Add(59 * time.Minute),
+			expectedOk: true,
+		},
+		"object without the condition is kept": {
+			obj: createPipelineRun(ts.Add(-time.Minute), "False"),
+		},
+		"object without completion time is kept": {
+			obj: createPipelineRun(time.Time{}, "True"),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			expiry, ok := resource.expiry(tc.obj)
+			if ok != tc.expectedOk || !expiry.Equal(tc.expiry) {
+				t.Fatalf("failed, expected %v %v, got %v %v", tc.expiry, tc.expectedOk, expiry, ok)
+			}
+		})
+	}
+}
+
+func TestNewResourceCleanup(t *testing.T) {
+	valid := ResourceConfig{
+		Group:          "argoproj.io",
+		Version:        "v1alpha1",
+		Resource:       "workflows",
+		CompletionTime: "{.status.finishedAt}",
+	}
+	testCases := map[string]struct {
+		update func(r *ResourceConfig)
+		err    bool
+	}{
+		"valid resource is accepted": {
+			update: func(r *ResourceConfig) {},
+		},
+		"missing resource is rejected": {
+			update: func(r *ResourceConfig) { r.Resource = "" },
+			err:    true,
+		},
+		"missing completion time is rejected": {
+			update: func(r *ResourceConfig) { r.CompletionTime = "" },
+			err:    true,
+		},
+		"invalid completion time path is rejected": {
+			update: func(r *ResourceConfig) { r.CompletionTime = "{.status.finishedAt" },
+			err:    true,
+		},
+		"invalid label selector is rejected": {
+			update: func(r *ResourceConfig) { r.LabelSelector = "app in (" },
+			err:    true,
+		},
+		"condition without type is rejected": {
+			update: func(r *ResourceConfig) { r.Condition = &ResourceCondition{Status: "True"} },
+			err:    true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := valid
+			tc.update(&r)
+			_, err := newResourceCleanup(r)
+			if (err != nil) != tc.err {
+				t.Fatalf("failed, expected error %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestNewKleaner_resources(t *testing.T) {
+	// clients do not connect until informers are started
+	restConfig := &rest.Config{Host: "http://127.0.0.1:1"}
+	kclient := kubernetes.NewForConfigOrDie(restConfig)
+	dclient := dynamic.NewForConfigOrDie(restConfig)
+	cfg := Config{
+		Resources: []ResourceConfig{{
+			Group:          "argoproj.io",
+			Version:        "v1alpha1",
+			Resource:       "workflows",
+			CompletionTime: "{.status.finishedAt}",
+			DeleteAfter:    metav1.Duration{Duration: time.Hour},
+		}},
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	kleaner := NewKleaner(context.Background(), kclient, dclient, cfg, nil, stopCh)
+	if kleaner.policyInformer != nil || kleaner.clusterPolicyInformer != nil {
+		t.Fatalf("failed, policy informers are created without cleanup policies enabled")
+	}
+	informers := kleaner.informersOf(metav1.NamespaceAll)
+	if informers.informerOf("workflows.argoproj.io") == nil {
+		t.Fatalf("failed, expected an informer of workflows, got %v", informers.resources)
+	}
+}
@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Actions supported by rules
+const (
+	RuleActionDelete  = "delete"
+	RuleActionKeep    = "keep"
+	RuleActionArchive = "archive"
+)
+
+// noOwner is used in RuleMatch.OwnerKinds to match objects without owner references
+const noOwner = "None"
+
+// Rule decides what happens to Jobs and Pods matching it. Rules are evaluated in order and the first
+// matching rule wins, objects that do not match any rule are handled by the delete-* settings.
+type Rule struct {
+	Name   string     `json:"name,omitempty"`
+	Match  RuleMatch  `json:"match"`
+	Action RuleAction `json:"action"`
+}
+
+// RuleMatch holds conditions that all have to be met, empty conditions match everything
+type RuleMatch struct {
+	// Kinds is a list of object kinds, `Job` or `Pod`
+	Kinds []string `json:"kinds,omitempty"`
+	// Namespaces is a list of glob patterns, e.g. `ci-*`
+	Namespaces []string              `json:"namespaces,omitempty"`
+	Selector   *metav1.LabelSelector `json:"selector,omitempty"`
+	// OwnerKinds is a list of owner kinds, `None` matches objects without owners
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+	// Phases is a list of pod phases, jobs are `Succeeded`, `Failed` or `Running`
+	Phases []string `json:"phases,omitempty"`
+	// Reasons is a list of pod status reasons, e.g. `Evicted`, or job failure reasons, e.g. `DeadlineExceeded`
+	Reasons []string `json:"reasons,omitempty"`
+	// ExitCodes is a list of exit codes, at least one terminated container of the pod has to match
+	ExitCodes []int32 `json:"exitCodes,omitempty"`
+	// Images is a list of glob patterns, at least one container image has to match. `*` also matches `/`
+	Images []string `json:"images,omitempty"`
+	// Expression is a CEL expression evaluated against the Job or Pod available as `object`
+	Expression string `json:"expression,omitempty"`
+}
+
+// RuleAction is applied to objects matching the rule
+type RuleAction struct {
+	// Type is one of `delete`, `keep` or `archive`
+	Type string `json:"type"`
+	// After is the time since the object finished (or was created if it did not finish yet), used by delete and archive
+	After metav1.Duration `json:"after,omitempty"`
+}
+
+// rule is the parsed version of Rule
+type rule struct {
+	Rule
+	selector   labels.Selector
+	images     []*regexp.Regexp
+	expression *expression
+}
+
+func newRule(r Rule, archiveDir string) (rule, error) {
+	parsed := rule{Rule: r, selector: labels.Everything()}
+	switch r.Action.Type {
+	case RuleActionDelete, RuleActionKeep:
+	case RuleActionArchive:
+		if archiveDir == "" {
+			return parsed, fmt.Errorf("archive action requires archiveDir to be set")
+		}
+	default:
+		return parsed, fmt.Errorf("unknown action '%s'", r.Action.Type)
+	}
+	for _, kind := range r.Match.Kinds {
+		if kind != "Job" && kind != "Pod" {
+			return parsed, fmt.Errorf("unsupported kind '%s'", kind)
+		}
+	}
+	for _, pattern := range r.Match.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return parsed, fmt.Errorf("invalid namespace pattern '%s': %v", pattern, err)
+		}
+	}
+	if r.Match.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(r.Match.Selector)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid selector: %v", err)
+		}
+		parsed.selector = selector
+	}
+	for _, pattern := range r.Match.Images {
+		expr := "^" + strings.ReplaceAll(strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*"), `\?`, ".") + "$"
+		parsed.images = append(parsed.images, regexp.MustCompile(expr))
+	}
+	if r.Match.Expression != "" {
+		expression, err := newExpression(r.Match.Expression)
+		if err != nil {
+			return parsed, err
+		}
+		parsed.expression = expression
+	}
+	return parsed, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMeta checks conditions common for all kinds
+func (r *rule) matchesMeta(kind string, meta *metav1.ObjectMeta) bool {
+	if len(r.Match.Kinds) > 0 && !containsString(r.Match.Kinds, kind) {
+		return false
+	}
+	if len(r.Match.Namespaces) > 0 {
+		matched := false
+		for _, pattern := range r.Match.Namespaces {
+			if ok, _ := path.Match(pattern, meta.Namespace); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if !r.selector.Matches(labels.Set(meta.Labels)) {
+		return false
+	}
+	if len(r.Match.OwnerKinds) > 0 {
+		if len(meta.OwnerReferences) == 0 {
+			return containsString(r.Match.OwnerKinds, noOwner)
+		}
+		for _, ow := range meta.OwnerReferences {
+			if containsString(r.Match.OwnerKinds, ow.Kind) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (r *rule) matchesImages(containers []corev1.Container) bool {
+	if len(r.images) == 0 {
+		return true
+	}
+	for _, c := range containers {
+		for _, image := range r.images {
+			if image.MatchString(c.Image) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *rule) matchesJob(job *batchv1.Job) bool {
+	if !r.matchesMeta("Job", &job.ObjectMeta) {
+		return false
+	}
+	if len(r.Match.Phases) > 0 && !containsString(r.Match.Phases, jobPhase(job)) {
+		return false
+	}
+	if len(r.Match.Reasons) > 0 && !containsString(r.Match.Reasons, jobFailureReason(job)) {
+		return false
+	}
+	// jobs do not have exit codes, only their pods do
+	if len(r.Match.ExitCodes) > 0 {
+		return false
+	}
+	return r.matchesImages(job.Spec.Template.Spec.Containers) && r.expression.matches(job)
+}
+
+func (r *rule) matchesPod(pod *corev1.Pod) bool {
+	if !r.matchesMeta("Pod", &pod.ObjectMeta) {
+		return false
+	}
+	if len(r.Match.Phases) > 0 && !containsString(r.Match.Phases, string(pod.Status.Phase)) {
+		return false
+	}
+	if len(r.Match.Reasons) > 0 && !containsString(r.Match.Reasons, pod.Status.Reason) {
+		return false
+	}
+	if len(r.Match.ExitCodes) > 0 && !podHasExitCode(pod, r.Match.ExitCodes) {
+		return false
+	}
+	return r.matchesImages(pod.Spec.Containers) && r.expression.matches(pod)
+}
+
+// shouldDelete returns true if the action of the rule requires deletion of an object in the phase finished at reference time
+func (r *rule) shouldDelete(phase string, reference time.Time) bool {
+	return isExpired(r.expiry(phase, reference))
+}
+
+// expiry returns the time an object in the phase finished at reference time has to be deleted at, ok is false
+// if it must be kept. Only finished and Pending objects are deleted, unless the rule lists the phase explicitly,
+// so rules without phases never delete running pods and jobs.
+func (r *rule) expiry(phase string, reference time.Time) (time.Time, bool) {
+	if r.Action.Type == RuleActionKeep || reference.IsZero() {
+		return time.Time{}, false
+	}
+	switch corev1.PodPhase(phase) {
+	case corev1.PodSucceeded, corev1.PodFailed, corev1.PodPending:
+	default:
+		if !containsString(r.Match.Phases, phase) {
+			return time.Time{}, false
+		}
+	}
+	return reference.Add(r.Action.After.Duration), true
+}
+
+func jobPhase(job *batchv1.Job) string {
+	if isFailed(job) {
+		return string(corev1.PodFailed)
+	}
+	if job.Status.Succeeded > 0 && !jobFinishTime(job).IsZero() {
+		return string(corev1.PodSucceeded)
+	}
+	return string(corev1.PodRunning)
+}
+
+func jobFailureReason(job *batchv1.Job) string {
+	for _, jc := range job.Status.Conditions {
+		if jc.Type == batchv1.JobFailed && jc.Status == corev1.ConditionTrue {
+			return jc.Reason
+		}
+	}
+	return ""
+}
+
+func podHasExitCode(pod *corev1.Pod, exitCodes []int32) bool {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Terminated == nil {
+			continue
+		}
+		for _, code := range exitCodes {
+			if cs.State.Terminated.ExitCode == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jobReferenceTime is the time rules count from: completion of the job or its creation if it is not finished
+func jobReferenceTime(job *batchv1.Job) time.Time {
+	if t := jobFinishTime(job); !t.IsZero() {
+		return t
+	}
+	return job.CreationTimestamp.Time
+}
+
+// podReferenceTime is the time rules count from: end of execution of the pod, the time it became
+// unschedulable or its creation
+func podReferenceTime(pod *corev1.Pod) time.Time {
+	if t := podFinishTime(pod); !t.IsZero() {
+		return t
+	}
+	if t := podLastTransitionTime(pod); !t.IsZero() {
+		return t
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// archiveObject writes the object as JSON to <dir>/<namespace>/<kind>-<name>-<uid>.json
+func archiveObject(dir string, obj runtime.Object, gvk schema.GroupVersionKind, meta *metav1.ObjectMeta) error {
+	obj = obj.DeepCopyObject()
+	// objects from informers come without type information
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	nsDir := filepath.Join(dir, meta.Namespace)
+	if err := os.MkdirAll(nsDir, 0o755); err != nil {
+		return err
+	}
+	filename := fmt.Sprintf("%s-%s-%s.json", strings.ToLower(gvk.Kind), meta.Name, meta.UID)
+	return os.WriteFile(filepath.Join(nsDir, filename), data, 0o644)
+}
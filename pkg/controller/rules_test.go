@@ -0,0 +1,340 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func createRule(t *testing.T, match RuleMatch) *rule {
+	r, err := newRule(Rule{Match: match, Action: RuleAction{Type: RuleActionDelete}}, "")
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	return &r
+}
+
+func TestNewRule(t *testing.T) {
+	testCases := map[string]struct {
+		rule       Rule
+		archiveDir string
+		err        bool
+	}{
+		"valid rule": {
+			rule: Rule{Match: RuleMatch{Kinds: []string{"Pod"}, Images: []string{"*/busybox:*"}}, Action: RuleAction{Type: RuleActionKeep}},
+		},
+		"unknown action": {
+			rule: Rule{Action: RuleAction{Type: "move"}},
+			err:  true,
+		},
+		"archive without directory": {
+			rule: Rule{Action: RuleAction{Type: RuleActionArchive}},
+			err:  true,
+		},
+		"archive with directory": {
+			rule:       Rule{Action: RuleAction{Type: RuleActionArchive}},
+			archiveDir: "/tmp",
+		},
+		"invalid expression": {
+			rule: Rule{Match: RuleMatch{Expression: "object.status =="}, Action: RuleAction{Type: RuleActionDelete}},
+			err:  true,
+		},
+		"unsupported kind": {
+			rule: Rule{Match: RuleMatch{Kinds: []string{"Deployment"}}, Action: RuleAction{Type: RuleActionDelete}},
+			err:  true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := newRule(tc.rule, tc.archiveDir)
+			if (err != nil) != tc.err {
+				t.Fatalf("failed, expected error %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRule_matchesPod(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "ci-42",
+			Labels:          map[string]string{"app": "tests"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Job"}},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Image: "registry.example.com/ci/runner:1.0"}},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137}}},
+			},
+		},
+	}
+	testCases := map[string]struct {
+		match    RuleMatch
+		expected bool
+	}{
+		"empty match": {
+			match:    RuleMatch{},
+			expected: true,
+		},
+		"all conditions": {
+			match: RuleMatch{
+				Kinds:      []string{"Pod"},
+				Namespaces: []string{"ci-*"},
+				Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "tests"}},
+				OwnerKinds: []string{"Job"},
+				Phases:     []string{"Failed"},
+				ExitCodes:  []int32{1, 137},
+				Images:     []string{"*/runner:*"},
+			},
+			expected: true,
+		},
+		"different kind": {
+			match:    RuleMatch{Kinds: []string{"Job"}},
+			expected: false,
+		},
+		"different namespace": {
+			match:    RuleMatch{Namespaces: []string{"default"}},
+			expected: false,
+		},
+		"orphaned only": {
+			match:    RuleMatch{OwnerKinds: []string{noOwner}},
+			expected: false,
+		},
+		"different phase": {
+			match:    RuleMatch{Phases: []string{"Succeeded"}},
+			expected: false,
+		},
+		"different reason": {
+			match:    RuleMatch{Reasons: []string{"Evicted"}},
+			expected: false,
+		},
+		"different exit code": {
+			match:    RuleMatch{ExitCodes: []int32{1}},
+			expected: false,
+		},
+		"different image": {
+			match:    RuleMatch{Images: []string{"busybox*"}},
+			expected: false,
+		},
+		"matching expression": {
+			match:    RuleMatch{Expression: `object.metadata.labels.app == "tests"`},
+			expected: true,
+		},
+		"different expression": {
+			match:    RuleMatch{Expression: `object.metadata.labels.app == "web"`},
+			expected: false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := createRule(t, tc.match).matchesPod(pod)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestRule_matchesJob(t *testing.T) {
+	ts := time.Now()
+	failed := createJob(true, time.Time{}, 0, 0, 0, []batchv1.JobCondition{
+		{
+			Type:               batchv1.JobFailed,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(ts.Add(-time.Minute)),
+			Reason:             "DeadlineExceeded",
+		},
+	})
+	succeeded := createJob(false, ts.Add(-time.Minute), 0, 1, 0, []batchv1.JobCondition{})
+	testCases := map[string]struct {
+		job      *batchv1.Job
+		match    RuleMatch
+		expected bool
+	}{
+		"failed job owned by cronjob": {
+			job:      failed,
+			match:    RuleMatch{Kinds: []string{"Job"}, OwnerKinds: []string{"CronJob"}, Phases: []string{"Failed"}, Reasons: []string{"DeadlineExceeded"}},
+			expected: true,
+		},
+		"succeeded orphaned job": {
+			job:      succeeded,
+			match:    RuleMatch{OwnerKinds: []string{noOwner}, Phases: []string{"Succeeded"}},
+			expected: true,
+		},
+		"succeeded job does not have failure reason": {
+			job:      succeeded,
+			match:    RuleMatch{Reasons: []string{"DeadlineExceeded"}},
+			expected: false,
+		},
+		"jobs do not have exit codes": {
+			job:      failed,
+			match:    RuleMatch{ExitCodes: []int32{1}},
+			expected: false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := createRule(t, tc.match).matchesJob(tc.job)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestRule_shouldDelete(t *testing.T) {
+	ts := time.Now()
+	testCases := map[string]struct {
+		action    RuleAction
+		phases    []string
+		phase     corev1.PodPhase
+		reference time.Time
+		expected  bool
+	}{
+		"expired object is deleted": {
+			action:    RuleAction{Type: RuleActionDelete, After: metav1.Duration{Duration: time.Minute}},
+			phase:     corev1.PodSucceeded,
+			reference: ts.Add(-time.Hour),
+			expected:  true,
+		},
+		"expired pending object is deleted": {
+			action:    RuleAction{Type: RuleActionDelete, After: metav1.Duration{Duration: time.Minute}},
+			phase:     corev1.PodPending,
+			reference: ts.Add(-time.Hour),
+			expected:  true,
+		},
+		"non expired object is not deleted": {
+			action:    RuleAction{Type: RuleActionArchive, After: metav1.Duration{Duration: time.Hour}},
+			phase:     corev1.PodFailed,
+			reference: ts.Add(-time.Minute),
+			expected:  false,
+		},
+		"kept object is not deleted": {
+			action:    RuleAction{Type: RuleActionKeep},
+			phase:     corev1.PodFailed,
+			reference: ts.Add(-time.Hour),
+			expected:  false,
+		},
+		"object without reference time is not deleted": {
+			action:   RuleAction{Type: RuleActionDelete},
+			phase:    corev1.PodFailed,
+			expected: false,
+		},
+		"running object is not deleted by rule without phases": {
+			action:    RuleAction{Type: RuleActionDelete, After: metav1.Duration{Duration: time.Minute}},
+			phase:     corev1.PodRunning,
+			reference: ts.Add(-time.Hour),
+			expected:  false,
+		},
+		"running object is deleted by rule listing running phase": {
+			action:    RuleAction{Type: RuleActionDelete, After: metav1.Duration{Duration: time.Minute}},
+			phases:    []string{string(corev1.PodRunning)},
+			phase:     corev1.PodRunning,
+			reference: ts.Add(-time.Hour),
+			expected:  true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := &rule{Rule: Rule{Match: RuleMatch{Phases: tc.phases}, Action: tc.action}}
+			result := r.shouldDelete(string(tc.phase), tc.reference)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestArchiveObject(t *testing.T) {
+	dir := t.TempDir()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "failed", UID: "1234"}}
+	if err := archiveObject(dir, pod, corev1.SchemeGroupVersion.WithKind("Pod"), &pod.ObjectMeta); err != nil {
+		t.Fatalf("failed to archive: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "default", "pod-failed-1234.json"))
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	var archived corev1.Pod
+	if err := json.Unmarshal(data, &archived); err != nil {
+		t.Fatalf("failed to parse archive: %v", err)
+	}
+	if archived.Kind != "Pod" || archived.APIVersion != "v1" || archived.Name != "failed" {
+		t.Fatalf("failed, unexpected archive content: %s", data)
+	}
+	if pod.Kind != "" {
+		t.Fatalf("failed, original object was modified")
+	}
+}
+
+func TestKleaner_archiveIfRequired(t *testing.T) {
+	full := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "failed", UID: "1234"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Image: "busybox", Command: []string{"false"}}}},
+	}
+	// objects of the informers are trimmed
+	trimmed, err := transformObject(full.DeepCopy())
+	if err != nil {
+		t.Fatalf("failed to trim: %v", err)
+	}
+	pod := trimmed.(*corev1.Pod)
+	testCases := map[string]struct {
+		get      func() (runtime.Object, error)
+		err      bool
+		archived bool
+	}{
+		"whole object is archived": {
+			get:      func() (runtime.Object, error) { return full, nil },
+			archived: true,
+		},
+		"replaced object is not archived": {
+			get: func() (runtime.Object, error) {
+				replaced := full.DeepCopy()
+				replaced.UID = "5678"
+				return replaced, nil
+			},
+			err: true,
+		},
+		"object which can not be read is not archived": {
+			get: func() (runtime.Object, error) { return nil, fmt.Errorf("connection refused") },
+			err: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			kleaner := &Kleaner{}
+			kleaner.settings.Store(&kleanerSettings{archiveDir: dir})
+			r := &rule{Rule: Rule{Action: RuleAction{Type: RuleActionArchive}}}
+			err := kleaner.archiveIfRequired(r, corev1.SchemeGroupVersion.WithKind("Pod"), &pod.ObjectMeta, tc.get)
+			if (err != nil) != tc.err {
+				t.Fatalf("failed, expected error %v, got %v", tc.err, err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "default", "pod-failed-1234.json"))
+			if (err == nil) != tc.archived {
+				t.Fatalf("failed, expected archived %v, got %v", tc.archived, err)
+			}
+			if !tc.archived {
+				return
+			}
+			var archived corev1.Pod
+			if err := json.Unmarshal(data, &archived); err != nil {
+				t.Fatalf("failed to parse archive: %v", err)
+			}
+			if len(archived.Spec.Containers) != 1 || len(archived.Spec.Containers[0].Command) != 1 {
+				t.Fatalf("failed, expected the whole object, got %s", data)
+			}
+		})
+	}
+}
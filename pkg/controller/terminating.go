@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// minForceDeleteAfter guards against force deleting pods which are just slow to terminate or of nodes
+	// which are about to recover
+	minForceDeleteAfter = time.Minute
+
+	podForceDeletedMetric       = "terminating_pods_force_deleted_total"
+	podForceDeletedFailedMetric = "terminating_pods_force_deleted_failed_total"
+	// podFinalizersRemovedMetric counts force deleted pods whose finalizers were removed, both terminating and of lost nodes
+	podFinalizersRemovedMetric = "pods_finalizers_removed_total"
+)
+
+// terminatingPodExpiry returns the time a pod stuck in Terminating has to be force deleted at, counted from
+// the end of its grace period. ok is false if the pod must not be force deleted: pods of StatefulSets,
+// which must never run twice while their node may still be running them, and pods with finalizers
+// that are not allowed to be removed.
+func terminatingPodExpiry(pod *corev1.Pod, after time.Duration, removableFinalizers []string) (expiry time.Time, ok bool) {
+	if after <= 0 || pod.DeletionTimestamp.IsZero() {
+		return time.Time{}, false
+	}
+	if ownedByAny(getPodOwnerKinds(pod), []string{"StatefulSet"}) {
+		return time.Time{}, false
+	}
+	if !finalizersRemovable(pod, removableFinalizers) {
+		return time.Time{}, false
+	}
+	return pod.DeletionTimestamp.Add(after), true
+}
+
+// finalizersRemovable returns true if every finalizer of the pod is allowed to be removed
+func finalizersRemovable(pod *corev1.Pod, removableFinalizers []string) bool {
+	for _, finalizer := range pod.Finalizers {
+		if !containsString(removableFinalizers, finalizer) {
+			return false
+		}
+	}
+	return true
+}
+
+// forceDeletePod removes the finalizers of the pod and deletes it with no grace period, so it is removed
+// from the apiserver without waiting for the kubelet to confirm its termination. The outcome is counted
+// in deletedMetric or failedMetric.
+func (c *Kleaner) forceDeletePod(pod *corev1.Pod, deletedMetric, failedMetric string) error {
+	if c.settings.Load().dryRun {
+		log.Printf("dry-run: Pod '%s:%s' would have been force deleted", pod.Namespace, pod.Name)
+		return nil
+	}
+	if len(pod.Finalizers) > 0 {
+		// the patch fails if the pod has changed since it was checked, e.g. a finalizer was added
+		patch, err := json.Marshal([]map[string]interface{}{
+			{"op": "test", "path": "/metadata/resourceVersion", "value": pod.ResourceVersion},
+			{"op": "remove", "path": "/metadata/finalizers"},
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Removing finalizers %v of pod '%s/%s'", pod.Finalizers, pod.Namespace, pod.Name)
+		_, err = c.kclient.CoreV1().Pods(pod.Namespace).Patch(c.ctx, pod.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+		if ignoreNotFound(err) != nil {
+			log.Printf("failed to remove finalizers of pod '%s:%s': %v", pod.Namespace, pod.Name, err)
+			metrics.GetOrCreateCounter(metricName(failedMetric, pod.Namespace)).Inc()
+			return err
+		}
+		metrics.GetOrCreateCounter(metricName(podFinalizersRemovedMetric, pod.Namespace)).Inc()
+	}
+	log.Printf("Force deleting pod '%s/%s'", pod.Namespace, pod.Name)
+	gracePeriod := int64(0)
+	po := metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod}
+	if err := c.kclient.CoreV1().Pods(pod.Namespace).Delete(c.ctx, pod.Name, po); ignoreNotFound(err) != nil {
+		log.Printf("failed to force delete pod '%s:%s': %v", pod.Namespace, pod.Name, err)
+		metrics.GetOrCreateCounter(metricName(failedMetric, pod.Namespace)).Inc()
+		return err
+	}
+	metrics.GetOrCreateCounter(metricName(deletedMetric, pod.Namespace)).Inc()
+	return nil
+}
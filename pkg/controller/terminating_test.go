@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createTerminatingPod(deleted time.Time, owner string, finalizers ...string) *corev1.Pod {
+	ts := metav1.NewTime(deleted)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "terminating",
+			DeletionTimestamp: &ts,
+			Finalizers:        finalizers,
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if owner != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: owner, Name: "owner"}}
+	}
+	return pod
+}
+
+func TestTerminatingPodExpiry(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	testCases := map[string]struct {
+		pod        *corev1.Pod
+		after      time.Duration
+		expiry     time.Time
+		expectedOk bool
+	}{
+		"pod is force deleted after the period": {
+			pod:        createTerminatingPod(ts, "ReplicaSet"),
+			after:      time.Hour,
+			expiry:     ts.Add(time.Hour),
+			expectedOk: true,
+		},
+		"pod is not force deleted if disabled": {
+			pod: createTerminatingPod(ts, "ReplicaSet"),
+		},
+		"pod with removable finalizers is force deleted": {
+			pod:        createTerminatingPod(ts, "", "example.com/cleanup"),
+			after:      time.Hour,
+			expiry:     ts.Add(time.Hour),
+			expectedOk: true,
+		},
+		"pod with other finalizers is not force deleted": {
+			pod:   createTerminatingPod(ts, "", "example.com/cleanup", "example.com/backup"),
+			after: time.Hour,
+		},
+		"pod of statefulset is not force deleted": {
+			pod:   createTerminatingPod(ts, "StatefulSet"),
+			after: time.Hour,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			expiry, ok := terminatingPodExpiry(tc.pod, tc.after, []string{"example.com/cleanup"})
+			if ok != tc.expectedOk || !expiry.Equal(tc.expiry) {
+				t.Fatalf("failed, expected %v %v, got %v %v", tc.expiry, tc.expectedOk, expiry, ok)
+			}
+		})
+	}
+}
+
+func TestKleaner_ProcessTerminating(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	pod := createTerminatingPod(ts.Add(-time.Minute), "ReplicaSet")
+	for name, tc := range map[string]struct {
+		after    time.Duration
+		expected time.Time
+	}{
+		"terminating pod is skipped if disabled": {},
+		"terminating pod is scheduled for force deletion": {
+			after:    time.Hour,
+			expected: ts.Add(59 * time.Minute),
+		},
+		"stuck pod is force deleted": {
+			after: time.Minute,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			kleaner := createTestKleaner(t, Config{
+				DryRun:                     true,
+				DeleteTerminatingPodsAfter: metav1.Duration{Duration: tc.after},
+			})
+			result, err := kleaner.Process(pod)
+			if err != nil {
+				t.Fatalf("failed to process: %v", err)
+			}
+			if !result.Equal(tc.expected) {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// lastAppliedConfigAnnotation holds a copy of the whole object applied with kubectl
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// transformObject strips the parts of Pods and Jobs the Kleaner never reads before they are stored
+// in the informer caches: managed fields and the spec, except for container images matched by rules
+// and the fields used to compute retention. Metadata and status are kept as is. Only the conditions
+// are kept from the status of Nodes, the spec of custom resources is dropped as a whole.
+func transformObject(obj interface{}) (interface{}, error) {
+	switch t := obj.(type) {
+	case *corev1.Pod:
+		stripObjectMeta(&t.ObjectMeta)
+		t.Spec = corev1.PodSpec{
+			NodeName:       t.Spec.NodeName,
+			Containers:     stripContainers(t.Spec.Containers),
+			InitContainers: stripContainers(t.Spec.InitContainers),
+		}
+	case *batchv1.Job:
+		stripObjectMeta(&t.ObjectMeta)
+		t.Spec = batchv1.JobSpec{
+			Parallelism:             t.Spec.Parallelism,
+			Completions:             t.Spec.Completions,
+			ActiveDeadlineSeconds:   t.Spec.ActiveDeadlineSeconds,
+			BackoffLimit:            t.Spec.BackoffLimit,
+			TTLSecondsAfterFinished: t.Spec.TTLSecondsAfterFinished,
+			Suspend:                 t.Spec.Suspend,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers:     stripContainers(t.Spec.Template.Spec.Containers),
+					InitContainers: stripContainers(t.Spec.Template.Spec.InitContainers),
+				},
+			},
+		}
+	case *corev1.Node:
+		stripObjectMeta(&t.ObjectMeta)
+		t.Spec = corev1.NodeSpec{}
+		t.Status = corev1.NodeStatus{Conditions: t.Status.Conditions}
+	case *unstructured.Unstructured:
+		t.SetManagedFields(nil)
+		if annotations := t.GetAnnotations(); annotations != nil {
+			if _, ok := annotations[lastAppliedConfigAnnotation]; ok {
+				delete(annotations, lastAppliedConfigAnnotation)
+				t.SetAnnotations(annotations)
+			}
+		}
+		delete(t.Object, "spec")
+	}
+	return obj, nil
+}
+
+func stripObjectMeta(meta *metav1.ObjectMeta) {
+	meta.ManagedFields = nil
+	if _, ok := meta.Annotations[lastAppliedConfigAnnotation]; ok {
+		annotations := make(map[string]string, len(meta.Annotations)-1)
+		for k, v := range meta.Annotations {
+			if k != lastAppliedConfigAnnotation {
+				annotations[k] = v
+			}
+		}
+		meta.Annotations = annotations
+	}
+}
+
+// stripContainers keeps only names and images of the containers
+func stripContainers(containers []corev1.Container) []corev1.Container {
+	if len(containers) == 0 {
+		return nil
+	}
+	result := make([]corev1.Container, len(containers))
+	for i, c := range containers {
+		result[i] = corev1.Container{Name: c.Name, Image: c.Image}
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+// createRealisticPod creates a pod of a job resembling the ones returned by the apiserver
+func createRealisticPod(i int) *corev1.Pod {
+	var env []corev1.EnvVar
+	for j := 0; j < 20; j++ {
+		env = append(env, corev1.EnvVar{Name: fmt.Sprintf("VARIABLE_%d", j), Value: strings.Repeat("v", 40)})
+	}
+	container := corev1.Container{
+		Name:    "main",
+		Image:   "registry.example.com/team/backup:1.2.3",
+		Command: []string{"/bin/sh", "-c", "run-backup --target=s3://bucket/path --verbose"},
+		Env:     env,
+		Resources: corev1.ResourceRequirements{
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "data", MountPath: "/data"},
+			{Name: "kube-api-access", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount", ReadOnly: true},
+		},
+	}
+	fields := metav1.FieldsV1{Raw: []byte(strings.Repeat(`{"f:metadata":{"f:labels":{}}}`, 40))}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            fmt.Sprintf("backup-%d-abcde", i),
+			Labels:          map[string]string{"app": "backup", "job-name": fmt.Sprintf("backup-%d", i)},
+			Annotations:     map[string]string{lastAppliedConfigAnnotation: strings.Repeat("x", 2000)},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: fmt.Sprintf("backup-%d", i)}},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate, FieldsV1: &fields},
+				{Manager: "kubelet", Operation: metav1.ManagedFieldsOperationUpdate, FieldsV1: &fields, Subresource: "status"},
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:       "node-1",
+			Containers:     []corev1.Container{container},
+			InitContainers: []corev1.Container{container},
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "kube-api-access", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{}}},
+			},
+			Tolerations: []corev1.Toleration{
+				{Key: "node.kubernetes.io/not-ready", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+				{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodSucceeded,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "main", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}},
+			},
+		},
+	}
+}
+
+func TestTransformObject(t *testing.T) {
+	pod := createRealisticPod(0)
+	pod.Annotations[keepAnnotation] = "true"
+	result, err := transformObject(pod.DeepCopy())
+	if err != nil {
+		t.Fatalf("failed to transform: %v", err)
+	}
+	transformed := result.(*corev1.Pod)
+	if transformed.ManagedFields != nil || transformed.Spec.Volumes != nil || transformed.Spec.Containers[0].Env != nil {
+		t.Fatalf("failed, unused fields are not stripped: %+v", transformed)
+	}
+	if _, ok := transformed.Annotations[lastAppliedConfigAnnotation]; ok {
+		t.Fatalf("failed, %s is not stripped", lastAppliedConfigAnnotation)
+	}
+	expectedContainers := []corev1.Container{{Name: "main", Image: pod.Spec.Containers[0].Image}}
+	if !reflect.DeepEqual(transformed.Spec.Containers, expectedContainers) || transformed.Spec.NodeName != pod.Spec.NodeName {
+		t.Fatalf("failed, expected containers %v, got %v", expectedContainers, transformed.Spec.Containers)
+	}
+	if !reflect.DeepEqual(transformed.Status, pod.Status) || !reflect.DeepEqual(transformed.OwnerReferences, pod.OwnerReferences) ||
+		!reflect.DeepEqual(transformed.Labels, pod.Labels) || transformed.Annotations[keepAnnotation] != "true" {
+		t.Fatalf("failed, fields used by the Kleaner are changed: %+v", transformed)
+	}
+
+	ttl := int32(60)
+	job := createJob(false, time.Now(), 0, 1, 0, []batchv1.JobCondition{})
+	job.Spec.TTLSecondsAfterFinished = &ttl
+	job.Spec.Template.Spec = pod.Spec
+	result, err = transformObject(job.DeepCopy())
+	if err != nil {
+		t.Fatalf("failed to transform: %v", err)
+	}
+	transformedJob := result.(*batchv1.Job)
+	if transformedJob.Spec.Template.Spec.Volumes != nil || *transformedJob.Spec.TTLSecondsAfterFinished != ttl ||
+		!reflect.DeepEqual(transformedJob.Spec.Template.Spec.Containers, expectedContainers) {
+		t.Fatalf("failed, unexpected job spec %+v", transformedJob.Spec)
+	}
+	if !reflect.DeepEqual(transformedJob.Status, job.Status) {
+		t.Fatalf("failed, expected status %+v, got %+v", job.Status, transformedJob.Status)
+	}
+
+	run := createPipelineRun(time.Now(), "True")
+	run.SetAnnotations(map[string]string{lastAppliedConfigAnnotation: "{}", keepAnnotation: "true"})
+	result, err = transformObject(run.DeepCopy())
+	if err != nil {
+		t.Fatalf("failed to transform: %v", err)
+	}
+	transformedRun := result.(*unstructured.Unstructured)
+	if _, ok := transformedRun.Object["spec"]; ok || !reflect.DeepEqual(transformedRun.Object["status"], run.Object["status"]) {
+		t.Fatalf("failed, unexpected object %+v", transformedRun.Object)
+	}
+	if !reflect.DeepEqual(transformedRun.GetAnnotations(), map[string]string{keepAnnotation: "true"}) {
+		t.Fatalf("failed, unexpected annotations %v", transformedRun.GetAnnotations())
+	}
+}
+
+// BenchmarkTransformObject reports the heap used by a cache of pods with and without the transform
+func BenchmarkTransformObject(b *testing.B) {
+	const pods = 10000
+	for name, transform := range map[string]cache.TransformFunc{
+		"full":        func(obj interface{}) (interface{}, error) { return obj, nil },
+		"transformed": transformObject,
+	} {
+		b.Run(name, func(b *testing.B) {
+			var stats runtime.MemStats
+			for n := 0; n < b.N; n++ {
+				store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+				runtime.GC()
+				runtime.ReadMemStats(&stats)
+				before := stats.HeapAlloc
+				for i := 0; i < pods; i++ {
+					obj, _ := transform(createRealisticPod(i))
+					if err := store.Add(obj); err != nil {
+						b.Fatalf("failed to add pod: %v", err)
+					}
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&stats)
+				b.ReportMetric(float64(stats.HeapAlloc-before)/pods, "heap-B/pod")
+				runtime.KeepAlive(store)
+			}
+		})
+	}
+}
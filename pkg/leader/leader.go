@@ -0,0 +1,130 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// isLeaderMetric is 1 while the replica holds the lease, 0 otherwise
+const isLeaderMetric = "leader_election_is_leader"
+
+// namespaceFile holds the namespace of the pod when running inside the cluster
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var isLeader atomic.Bool
+
+// exitf exits the process after a lost lease, it is replaced in tests
+var exitf = log.Fatalf
+
+// Config holds the settings of the Lease based leader election
+type Config struct {
+	// LeaseName and LeaseNamespace identify the Lease object, empty namespace - namespace of the pod
+	LeaseName      string
+	LeaseNamespace string
+	// Identity of the replica, empty - hostname, i.e. the pod name
+	Identity string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// DefaultNamespace returns the namespace of the pod or `default` when running outside of the cluster
+func DefaultNamespace() string {
+	if data, err := os.ReadFile(namespaceFile); err == nil {
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace
+		}
+	}
+	return "default"
+}
+
+// Run blocks until stopCh is closed and calls run while the replica is the leader. The channel passed to run
+// is closed when stopCh is closed or the leadership is lost. A replica that lost the leadership can not tell
+// whether run is done with the objects it started processing, so the process exits to be restarted as a follower.
+func Run(ctx context.Context, kclient kubernetes.Interface, cfg Config, stopCh <-chan struct{}, run func(stopCh <-chan struct{})) error {
+	if cfg.LeaseNamespace == "" {
+		cfg.LeaseNamespace = DefaultNamespace()
+	}
+	if cfg.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname: %v", err)
+		}
+		cfg.Identity = hostname
+	}
+	// the metric is only reported by replicas taking part in the election
+	metrics.GetOrCreateGauge(isLeaderMetric, func() float64 {
+		if isLeader.Load() {
+			return 1
+		}
+		return 0
+	})
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		cfg.LeaseNamespace,
+		cfg.LeaseName,
+		kclient.CoreV1(),
+		kclient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create lease lock: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            cfg.LeaseName,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("%s acquired lease %s/%s", cfg.Identity, cfg.LeaseNamespace, cfg.LeaseName)
+				isLeader.Store(true)
+				run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				isLeader.Store(false)
+				select {
+				case <-stopCh:
+					log.Printf("%s released lease %s/%s", cfg.Identity, cfg.LeaseNamespace, cfg.LeaseName)
+				default:
+					exitf("%s lost lease %s/%s, exiting", cfg.Identity, cfg.LeaseNamespace, cfg.LeaseName)
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != cfg.Identity {
+					log.Printf("waiting for lease %s/%s, current leader is %s", cfg.LeaseNamespace, cfg.LeaseName, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid leader election settings: %v", err)
+	}
+	log.Printf("%s is waiting for lease %s/%s", cfg.Identity, cfg.LeaseNamespace, cfg.LeaseName)
+	elector.Run(ctx)
+	return nil
+}
@@ -0,0 +1,105 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func createConfig() Config {
+	return Config{
+		LeaseName:     "kube-cleanup-operator",
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+}
+
+// runLeader starts Run and waits until the replica leads, the returned channel is closed when Run returns
+func runLeader(t *testing.T, kclient *fake.Clientset, cfg Config, stopCh chan struct{}) <-chan struct{} {
+	leading := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := Run(context.Background(), kclient, cfg, stopCh, func(stopCh <-chan struct{}) {
+			close(leading)
+			<-stopCh
+		})
+		if err != nil {
+			t.Errorf("failed to run: %v", err)
+		}
+	}()
+	select {
+	case <-leading:
+	case <-done:
+		t.Fatalf("failed, Run returned without leading")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failed, lease is not acquired")
+	}
+	return done
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failed, Run did not return")
+	}
+}
+
+func TestRun_defaults(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("failed to get hostname: %v", err)
+	}
+	kclient := fake.NewSimpleClientset()
+	stopCh := make(chan struct{})
+	done := runLeader(t, kclient, createConfig(), stopCh)
+
+	lease, err := kclient.CoordinationV1().Leases(DefaultNamespace()).Get(context.Background(), "kube-cleanup-operator", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != hostname {
+		t.Fatalf("failed, expected holder %s, got %v", hostname, lease.Spec.HolderIdentity)
+	}
+	if !isLeader.Load() {
+		t.Fatalf("failed, expected to be the leader")
+	}
+	close(stopCh)
+	waitDone(t, done)
+	if isLeader.Load() {
+		t.Fatalf("failed, expected not to be the leader after stopping")
+	}
+}
+
+func TestRun_lostLease(t *testing.T) {
+	lost := make(chan string, 1)
+	defer func(f func(string, ...interface{})) { exitf = f }(exitf)
+	exitf = func(format string, args ...interface{}) { lost <- fmt.Sprintf(format, args...) }
+
+	kclient := fake.NewSimpleClientset()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	cfg := createConfig()
+	cfg.LeaseNamespace, cfg.Identity = "cleanup", "replica-a"
+	done := runLeader(t, kclient, cfg, stopCh)
+
+	// the lease can not be renewed anymore, e.g. the apiserver is unavailable
+	kclient.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("apiserver is unavailable")
+	})
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failed, lost lease is not detected")
+	}
+	waitDone(t, done)
+}
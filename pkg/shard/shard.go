@@ -0,0 +1,276 @@
+package shard
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// GroupLabel is set on the Leases of the members to the name of their group
+const GroupLabel = "cleanup.kube-cleanup-operator/shard-group"
+
+// Config holds the settings of the shard membership
+type Config struct {
+	// Group names the set of replicas splitting the work, it prefixes the names of their Leases
+	Group string
+	// Namespace of the Leases
+	Namespace string
+	// Identity of the replica, empty - hostname, i.e. the pod name
+	Identity string
+	// LeaseDuration is the time after which a member that stopped renewing its Lease is considered gone
+	LeaseDuration time.Duration
+	// Label is a namespace label whose value is hashed instead of the namespace name,
+	// so namespaces with the same value, e.g. of a single tenant, are assigned to the same member
+	Label string
+}
+
+// Members tracks the replicas of a group through their Leases. Every replica renews its own Lease,
+// the keys are assigned to the live members with rendezvous hashing, so when a member joins or leaves
+// only the keys it gains or loses move.
+type Members struct {
+	kclient  kubernetes.Interface
+	ctx      context.Context
+	cfg      Config
+	informer cache.SharedIndexInformer
+
+	mu       sync.RWMutex
+	members  []string
+	onChange []func()
+}
+
+// NewMembers creates the membership of the replica in the group
+func NewMembers(ctx context.Context, kclient kubernetes.Interface, cfg Config) (*Members, error) {
+	if cfg.Group == "" {
+		return nil, fmt.Errorf("shard group can not be empty")
+	}
+	if cfg.LeaseDuration < time.Second {
+		return nil, fmt.Errorf("shard lease duration has to be at least 1s")
+	}
+	if cfg.Namespace == "" {
+		return nil, fmt.Errorf("shard lease namespace can not be empty")
+	}
+	if cfg.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %v", err)
+		}
+		cfg.Identity = hostname
+	}
+	labelSelector := labels.SelectorFromSet(labels.Set{GroupLabel: cfg.Group}).String()
+	m := &Members{
+		kclient: kclient,
+		ctx:     ctx,
+		cfg:     cfg,
+		members: []string{cfg.Identity},
+	}
+	m.informer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = labelSelector
+				return kclient.CoordinationV1().Leases(cfg.Namespace).List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = labelSelector
+				return kclient.CoordinationV1().Leases(cfg.Namespace).Watch(ctx, options)
+			},
+		},
+		&coordinationv1.Lease{},
+		0,
+		cache.Indexers{},
+	)
+	m.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { m.refresh() },
+		UpdateFunc: func(old, new interface{}) { m.refresh() },
+		DeleteFunc: func(interface{}) { m.refresh() },
+	})
+	return m, nil
+}
+
+// OnChange registers a function called every time the set of live members changes
+func (m *Members) OnChange(f func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = append(m.onChange, f)
+}
+
+// HasSynced returns true once the Leases of the other members are known
+func (m *Members) HasSynced() bool {
+	return m.informer.HasSynced()
+}
+
+// Run renews the Lease of the replica until stopCh is closed, then deletes it so the keys move
+// to the remaining members right away instead of after the lease duration
+func (m *Members) Run(stopCh <-chan struct{}) {
+	go m.informer.Run(stopCh)
+	ticker := time.NewTicker(m.cfg.LeaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		if err := m.renew(); err != nil {
+			log.Printf("failed to renew shard lease %s/%s: %v", m.cfg.Namespace, m.leaseName(), err)
+		}
+		// members which stopped renewing do not trigger any events
+		m.refresh()
+		select {
+		case <-stopCh:
+			err := m.kclient.CoordinationV1().Leases(m.cfg.Namespace).Delete(context.Background(), m.leaseName(), metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				log.Printf("failed to delete shard lease %s/%s: %v", m.cfg.Namespace, m.leaseName(), err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Members) leaseName() string {
+	return m.cfg.Group + "-" + m.cfg.Identity
+}
+
+func (m *Members) renew() error {
+	leases := m.kclient.CoordinationV1().Leases(m.cfg.Namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(m.cfg.LeaseDuration / time.Second)
+	lease, err := leases.Get(m.ctx, m.leaseName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = leases.Create(m.ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.leaseName(),
+				Namespace: m.cfg.Namespace,
+				Labels:    map[string]string{GroupLabel: m.cfg.Group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.cfg.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &m.cfg.Identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(m.ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// liveMembers returns the sorted identities of the members with unexpired Leases, the replica itself is always a member
+func liveMembers(leases []interface{}, self string, now time.Time) []string {
+	members := []string{self}
+	for _, obj := range leases {
+		lease, ok := obj.(*coordinationv1.Lease)
+		if !ok || lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == self {
+			continue
+		}
+		if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		if expiry.After(now) {
+			members = append(members, *lease.Spec.HolderIdentity)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (m *Members) refresh() {
+	members := liveMembers(m.informer.GetStore().List(), m.cfg.Identity, time.Now())
+	m.mu.Lock()
+	changed := !equal(members, m.members)
+	m.members = members
+	callbacks := m.onChange
+	m.mu.Unlock()
+	if !changed {
+		return
+	}
+	log.Printf("shard group %s has %d members: %v", m.cfg.Group, len(members), members)
+	for _, f := range callbacks {
+		f()
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Owns returns true if the key is assigned to the replica
+func (m *Members) Owns(key string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return owner(key, m.members) == m.cfg.Identity
+}
+
+// OwnsNamespace returns true if the namespace is assigned to the replica
+func (m *Members) OwnsNamespace(name string, labels map[string]string) bool {
+	return m.Owns(namespaceKey(name, labels, m.cfg.Label))
+}
+
+func namespaceKey(name string, labels map[string]string, label string) string {
+	if value, ok := labels[label]; ok && label != "" {
+		return label + "=" + value
+	}
+	return name
+}
+
+// Size returns the number of live members
+func (m *Members) Size() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.members)
+}
+
+// owner returns the member with the highest weight for the key
+func owner(key string, members []string) string {
+	var (
+		result string
+		max    uint64
+	)
+	for _, member := range members {
+		if w := weight(member, key); result == "" || w > max {
+			result, max = member, w
+		}
+	}
+	return result
+}
+
+func weight(member, key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	// fnv alone distributes similar keys poorly, mix the bits with the murmur3 finalizer
+	x := binary.BigEndian.Uint64(h.Sum(nil))
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
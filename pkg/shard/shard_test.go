@@ -0,0 +1,93 @@
+package shard
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createLease(identity string, renewTime time.Time, seconds int32) *coordinationv1.Lease {
+	renew := metav1.NewMicroTime(renewTime)
+	return &coordinationv1.Lease{
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &identity,
+			RenewTime:            &renew,
+			LeaseDurationSeconds: &seconds,
+		},
+	}
+}
+
+func TestLiveMembers(t *testing.T) {
+	now := time.Now()
+	leases := []interface{}{
+		createLease("replica-b", now.Add(-10*time.Second), 30),
+		createLease("replica-c", now.Add(-time.Minute), 30),
+		createLease("self", now.Add(-time.Hour), 30),
+		&coordinationv1.Lease{},
+	}
+	expected := []string{"replica-b", "self"}
+	result := liveMembers(leases, "self", now)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("failed, expected %v, got %v", expected, result)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	members := []string{"replica-a", "replica-b", "replica-c"}
+	counts := make(map[string]int)
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("namespace-%d", i)
+		result := owner(key, members)
+		counts[result]++
+		// only the keys of the member that left are reassigned
+		if after := owner(key, members[:2]); after != result && result != "replica-c" {
+			moved++
+		}
+	}
+	for _, member := range members {
+		if counts[member] < 800 {
+			t.Fatalf("failed, keys are not distributed evenly: %v", counts)
+		}
+	}
+	if moved != 0 {
+		t.Fatalf("failed, expected 0 keys to move, got %d", moved)
+	}
+	if result := owner("namespace", nil); result != "" {
+		t.Fatalf("failed, expected no owner, got %v", result)
+	}
+}
+
+func TestNamespaceKey(t *testing.T) {
+	testCases := map[string]struct {
+		labels   map[string]string
+		label    string
+		expected string
+	}{
+		"name is used by default": {
+			labels:   map[string]string{"tenant": "acme"},
+			expected: "team-a",
+		},
+		"label value is used if set": {
+			labels:   map[string]string{"tenant": "acme"},
+			label:    "tenant",
+			expected: "tenant=acme",
+		},
+		"name is used if the label is missing": {
+			label:    "tenant",
+			expected: "team-a",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := namespaceKey("team-a", tc.labels, tc.label)
+			if result != tc.expected {
+				t.Fatalf("failed, expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
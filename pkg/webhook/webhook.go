@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/lwolf/kube-cleanup-operator/pkg/controller"
+)
+
+// MutatePath is the path the webhook is served at
+const MutatePath = "/mutate"
+
+// maxRequestSize limits the size of AdmissionReview requests, the apiserver caps objects at 3MB
+const maxRequestSize = 4 << 20
+
+// Server is a mutating admission webhook that sets spec.ttlSecondsAfterFinished of Jobs and CronJob job templates
+// which do not set it, so most of the cleanup is done by the TTL-after-finished controller of the apiserver
+type Server struct {
+	server   *http.Server
+	certFile string
+	keyFile  string
+
+	// ttl in seconds set on mutated objects, negative - objects are not mutated
+	ttl atomic.Int64
+	// scope holds the jobs the operator deletes, other jobs are not mutated
+	scope atomic.Pointer[controller.JobScope]
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// NewServer creates a webhook server listening on addr with the given TLS certificate
+func NewServer(addr, certFile, keyFile string, cfg controller.Config) *Server {
+	s := &Server{certFile: certFile, keyFile: keyFile}
+	mux := http.NewServeMux()
+	mux.Handle(MutatePath, s)
+	s.server = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	s.UpdateConfig(cfg)
+	return s
+}
+
+// ttlSeconds returns the ttl matching the delete-successful-after and delete-failed-after settings. ttl applies to
+// jobs of both outcomes, so the larger one is used and nothing is injected if deletion of either outcome is disabled.
+// Nothing is injected either if the last jobs are kept by count, the ttl would delete them regardless of the count.
+func ttlSeconds(cfg controller.Config) (int64, bool) {
+	if cfg.DryRun || cfg.KeepLastSuccessfulJobs > 0 || cfg.KeepLastFailedJobs > 0 {
+		return 0, false
+	}
+	successful, failed := cfg.DeleteSuccessfulAfter.Duration, cfg.DeleteFailedAfter.Duration
+	if successful <= 0 || failed <= 0 {
+		return 0, false
+	}
+	ttl := successful
+	if failed > ttl {
+		ttl = failed
+	}
+	// round up, so jobs are not deleted earlier than the operator would do it
+	return int64((ttl + time.Second - 1) / time.Second), true
+}
+
+// UpdateConfig changes the ttl set on mutated objects and the scope of jobs it is set on
+func (s *Server) UpdateConfig(cfg controller.Config) {
+	scope, err := controller.NewJobScope(cfg)
+	if err != nil {
+		log.Printf("webhook: ttlSecondsAfterFinished is not injected, invalid config: %v", err)
+		s.ttl.Store(-1)
+		return
+	}
+	s.scope.Store(scope)
+	ttl, ok := ttlSeconds(cfg)
+	if !ok {
+		log.Printf("webhook: ttlSecondsAfterFinished is not injected, dry-run or keep-last-*-jobs is enabled or delete-successful-after/delete-failed-after is 0")
+		s.ttl.Store(-1)
+		return
+	}
+	s.ttl.Store(ttl)
+}
+
+// Run serves the webhook until stopCh is closed
+func (s *Server) Run(stopCh <-chan struct{}) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.server.ListenAndServeTLS(s.certFile, s.keyFile)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-stopCh:
+		log.Println("shutting webhook server down")
+		return s.server.Shutdown(context.Background())
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+	review.Response = s.review(review.Request)
+	review.Request = nil
+	resp, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(resp); err != nil {
+		log.Printf("webhook: failed to write response: %v", err)
+	}
+}
+
+// review never rejects objects, at worst they are admitted without changes
+func (s *Server) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	ttl := s.ttl.Load()
+	if ttl < 0 || req.Operation != admissionv1.Create {
+		return resp
+	}
+	patch, err := mutate(req, ttl, s.scope.Load())
+	if err != nil {
+		log.Printf("webhook: failed to mutate %s '%s:%s': %v", req.Kind.Kind, req.Namespace, req.Name, err)
+		return resp
+	}
+	if len(patch) > 0 {
+		patchType := admissionv1.PatchTypeJSONPatch
+		resp.Patch = patch
+		resp.PatchType = &patchType
+	}
+	return resp
+}
+
+// mutate returns a JSON patch setting ttlSecondsAfterFinished or nil if the object does not need it.
+// Jobs out of the scope, i.e. never deleted by the operator, and CronJobs creating such jobs are not mutated.
+func mutate(req *admissionv1.AdmissionRequest, ttl int64, scope *controller.JobScope) ([]byte, error) {
+	var (
+		job  *batchv1.Job
+		path string
+	)
+	switch req.Kind.Kind {
+	case "Job":
+		job = &batchv1.Job{}
+		if err := json.Unmarshal(req.Object.Raw, job); err != nil {
+			return nil, err
+		}
+		path = "/spec/ttlSecondsAfterFinished"
+	case "CronJob":
+		cronJob := &batchv1.CronJob{}
+		if err := json.Unmarshal(req.Object.Raw, cronJob); err != nil {
+			return nil, err
+		}
+		// labels and annotations of the template are copied to every job, the job is owned by the CronJob
+		job = &batchv1.Job{ObjectMeta: cronJob.Spec.JobTemplate.ObjectMeta, Spec: cronJob.Spec.JobTemplate.Spec}
+		job.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "CronJob", Name: cronJob.Name}}
+		path = "/spec/jobTemplate/spec/ttlSecondsAfterFinished"
+	default:
+		return nil, nil
+	}
+	// metadata.namespace may be empty, the namespace of the request is the one the job is created in
+	job.Namespace = req.Namespace
+	if job.Spec.TTLSecondsAfterFinished != nil || hasCleanupAnnotations(&job.ObjectMeta) || !scope.Contains(job) {
+		return nil, nil
+	}
+	return json.Marshal([]patchOperation{{Op: "add", Path: path, Value: ttl}})
+}
+
+// hasCleanupAnnotations returns true if retention of the object is customized with annotations of the operator,
+// such objects are left to the operator
+func hasCleanupAnnotations(meta *metav1.ObjectMeta) bool {
+	for key := range meta.Annotations {
+		if strings.HasPrefix(key, controller.AnnotationPrefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/lwolf/kube-cleanup-operator/pkg/controller"
+)
+
+func createConfig(successful, failed time.Duration) controller.Config {
+	return controller.Config{
+		DeleteSuccessfulAfter: metav1.Duration{Duration: successful},
+		DeleteFailedAfter:     metav1.Duration{Duration: failed},
+	}
+}
+
+func createRequest(kind, object string) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:       "42",
+		Namespace: "default",
+		Kind:      metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: kind},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(object)},
+	}
+}
+
+func TestTTLSeconds(t *testing.T) {
+	dryRun := createConfig(time.Minute, time.Hour)
+	dryRun.DryRun = true
+	keepLast := createConfig(time.Minute, time.Hour)
+	keepLast.KeepLastFailedJobs = 3
+	testCases := map[string]struct {
+		config   controller.Config
+		expected int64
+		ok       bool
+	}{
+		"larger duration is used": {
+			config:   createConfig(15*time.Minute, time.Hour),
+			expected: 3600,
+			ok:       true,
+		},
+		"fractions are rounded up": {
+			config:   createConfig(1500*time.Millisecond, time.Second),
+			expected: 2,
+			ok:       true,
+		},
+		"failed jobs are never deleted": {
+			config: createConfig(15*time.Minute, 0),
+			ok:     false,
+		},
+		"dry-run": {
+			config: dryRun,
+			ok:     false,
+		},
+		"last jobs are kept by count": {
+			config: keepLast,
+			ok:     false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, ok := ttlSeconds(tc.config)
+			if result != tc.expected || ok != tc.ok {
+				t.Fatalf("failed, expected %v/%v, got %v/%v", tc.expected, tc.ok, result, ok)
+			}
+		})
+	}
+}
+
+func TestMutate(t *testing.T) {
+	testCases := map[string]struct {
+		req      *admissionv1.AdmissionRequest
+		config   controller.Config
+		expected string
+	}{
+		"job without ttl": {
+			req:      createRequest("Job", `{"spec":{"template":{}}}`),
+			expected: `[{"op":"add","path":"/spec/ttlSecondsAfterFinished","value":60}]`,
+		},
+		"job with ttl": {
+			req:      createRequest("Job", `{"spec":{"ttlSecondsAfterFinished":0}}`),
+			expected: "",
+		},
+		"job with cleanup annotations": {
+			req:      createRequest("Job", `{"metadata":{"annotations":{"cleanup.kube-cleanup-operator/keep":"true"}},"spec":{}}`),
+			expected: "",
+		},
+		"cronjob without ttl": {
+			req:      createRequest("CronJob", `{"spec":{"schedule":"* * * * *","jobTemplate":{"spec":{}}}}`),
+			expected: `[{"op":"add","path":"/spec/jobTemplate/spec/ttlSecondsAfterFinished","value":60}]`,
+		},
+		"cronjob with cleanup annotations in the template": {
+			req:      createRequest("CronJob", `{"spec":{"jobTemplate":{"metadata":{"annotations":{"cleanup.kube-cleanup-operator/delete-failed-after":"1h"}}}}}`),
+			expected: "",
+		},
+		"cronjob is skipped": {
+			req:      createRequest("CronJob", `{"spec":{"schedule":"* * * * *","jobTemplate":{"spec":{}}}}`),
+			config:   controller.Config{IgnoreOwnedByCronjobs: true},
+			expected: "",
+		},
+		"job of cronjob is skipped": {
+			req:      createRequest("Job", `{"metadata":{"ownerReferences":[{"apiVersion":"batch/v1","kind":"CronJob","name":"backup","uid":"1"}]},"spec":{}}`),
+			config:   controller.Config{ExcludeOwnedBy: []string{"CronJob"}},
+			expected: "",
+		},
+		"job without owner is patched when cronjobs are skipped": {
+			req:      createRequest("Job", `{"spec":{}}`),
+			config:   controller.Config{IgnoreOwnedByCronjobs: true},
+			expected: `[{"op":"add","path":"/spec/ttlSecondsAfterFinished","value":60}]`,
+		},
+		"job of another namespace is skipped": {
+			req:      createRequest("Job", `{"spec":{}}`),
+			config:   controller.Config{Namespace: "ci"},
+			expected: "",
+		},
+		"job of a namespace which is not listed is skipped": {
+			req:      createRequest("Job", `{"spec":{}}`),
+			config:   controller.Config{Namespaces: []string{"ci"}},
+			expected: "",
+		},
+		"job of an excluded namespace is skipped": {
+			req:      createRequest("Job", `{"spec":{}}`),
+			config:   controller.Config{ExcludeNamespaces: []string{"def*"}},
+			expected: "",
+		},
+		"job of a selected namespace is patched": {
+			req:      createRequest("Job", `{"spec":{}}`),
+			config:   controller.Config{NamespaceSelector: "team=ci"},
+			expected: `[{"op":"add","path":"/spec/ttlSecondsAfterFinished","value":60}]`,
+		},
+		"job not matching the label selector is skipped": {
+			req:      createRequest("Job", `{"metadata":{"labels":{"app":"web"}},"spec":{}}`),
+			config:   controller.Config{JobLabelSelector: "app=batch"},
+			expected: "",
+		},
+		"excluded job is skipped": {
+			req:      createRequest("Job", `{"metadata":{"labels":{"app":"web"}},"spec":{}}`),
+			config:   controller.Config{ExcludeJobSelector: "app=web"},
+			expected: "",
+		},
+		"cronjob creating excluded jobs is skipped": {
+			req:      createRequest("CronJob", `{"spec":{"jobTemplate":{"metadata":{"labels":{"app":"web"}},"spec":{}}}}`),
+			config:   controller.Config{ExcludeJobSelector: "app=web"},
+			expected: "",
+		},
+		"other kinds": {
+			req:      createRequest("Pod", `{"spec":{}}`),
+			expected: "",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			scope, err := controller.NewJobScope(tc.config)
+			if err != nil {
+				t.Fatalf("failed to create scope: %v", err)
+			}
+			result, err := mutate(tc.req, 60, scope)
+			if err != nil {
+				t.Fatalf("failed to mutate: %v", err)
+			}
+			if string(result) != tc.expected {
+				t.Fatalf("failed, expected %v, got %s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestServer_ServeHTTP(t *testing.T) {
+	testCases := map[string]struct {
+		config    controller.Config
+		operation admissionv1.Operation
+		patched   bool
+	}{
+		"job is patched": {
+			config:    createConfig(time.Minute, time.Minute),
+			operation: admissionv1.Create,
+			patched:   true,
+		},
+		"updates are not patched": {
+			config:    createConfig(time.Minute, time.Minute),
+			operation: admissionv1.Update,
+			patched:   false,
+		},
+		"injection is disabled": {
+			config:    createConfig(time.Minute, 0),
+			operation: admissionv1.Create,
+			patched:   false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := NewServer("", "", "", tc.config)
+			req := createRequest("Job", `{"spec":{}}`)
+			req.Operation = tc.operation
+			body, _ := json.Marshal(admissionv1.AdmissionReview{TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"}, Request: req})
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, MutatePath, bytes.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("failed, unexpected status %d: %s", w.Code, w.Body.String())
+			}
+			review := admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			if review.Response == nil || review.Response.UID != "42" || !review.Response.Allowed {
+				t.Fatalf("failed, unexpected response %+v", review.Response)
+			}
+			if (len(review.Response.Patch) > 0) != tc.patched {
+				t.Fatalf("failed, expected patched %v, got %s", tc.patched, review.Response.Patch)
+			}
+		})
+	}
+}
+
+func TestServer_ServeHTTPInvalidRequest(t *testing.T) {
+	server := NewServer("", "", "", createConfig(time.Minute, time.Minute))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, MutatePath, bytes.NewReader([]byte("{}"))))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("failed, expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}